@@ -0,0 +1,17 @@
+package builtin
+
+const ntpRecipe = `-- Install and start the NTP daemon (chrony).
+local cofu = require "cofu"
+
+local service_name = "chronyd"
+if cofu.os_family == "debian" or cofu.os_family == "ubuntu" then
+    service_name = "chrony"
+end
+
+software_package "chrony" {}
+
+service (service_name) {
+    action = {"enable", "start"},
+    depends_on = "software_package[chrony]",
+}
+`
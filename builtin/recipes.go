@@ -0,0 +1,22 @@
+package builtin
+
+import (
+	"strings"
+)
+
+// Recipes are the recipes embedded in the cofu binary. They are included by 'include_recipe "builtin:NAME"'.
+// The first comment line of each recipe is its description.
+var Recipes = map[string]string{
+	"ntp":      ntpRecipe,
+	"timezone": timezoneRecipe,
+}
+
+// Description returns the first comment line of the recipe.
+func Description(recipe string) string {
+	line := strings.SplitN(recipe, "\n", 2)[0]
+	if !strings.HasPrefix(line, "--") {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(line, "--"))
+}
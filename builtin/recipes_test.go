@@ -0,0 +1,65 @@
+package builtin
+
+import (
+	"github.com/kohkimakimoto/cofu/cofu"
+	"github.com/yuin/gopher-lua"
+	"strings"
+	"testing"
+)
+
+func TestRecipes(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	for name, recipe := range Recipes {
+		if _, err := L.LoadString(recipe); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		if Description(recipe) == "" {
+			t.Errorf("%s: the recipe must have a description in the first line", name)
+		}
+	}
+}
+
+func TestTimezoneRecipe(t *testing.T) {
+	cases := map[string]string{
+		"Asia/Tokyo":        "test -f '/usr/share/zoneinfo/Asia/Tokyo' && timedatectl set-timezone 'Asia/Tokyo'",
+		"Etc/GMT+9":         "test -f '/usr/share/zoneinfo/Etc/GMT+9' && timedatectl set-timezone 'Etc/GMT+9'",
+		"UTC; rm -rf /":     "",
+		"../../etc/passwd":  "",
+		"Asia/Tokyo'$(id)'": "",
+	}
+
+	for timezone, expected := range cases {
+		app := cofu.NewApp()
+		app.ResourceTypes = []*cofu.ResourceType{
+			{
+				Name: "execute",
+				Attributes: []cofu.Attribute{
+					&cofu.StringAttribute{Name: "command", DefaultName: true},
+				},
+			},
+		}
+		app.BuiltinRecipes = Recipes
+		if err := app.Init(); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.LoadVariableFromMap(map[string]interface{}{"timezone": timezone}); err != nil {
+			t.Fatal(err)
+		}
+
+		err := app.LoadBuiltinRecipe("timezone")
+		if expected == "" {
+			if err == nil || !strings.Contains(err.Error(), "invalid timezone") {
+				t.Errorf("%s: invalid timezone must be an error but got %v", timezone, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", timezone, err)
+		} else if len(app.Resources) != 1 || app.Resources[0].Name != expected {
+			t.Errorf("%s: unexpected resources %v", timezone, app.Resources)
+		}
+
+		app.Close()
+	}
+}
@@ -0,0 +1,15 @@
+package builtin
+
+const timezoneRecipe = `-- Set the system timezone to var.timezone (default: UTC).
+local timezone = var.timezone or "UTC"
+
+-- the timezone must be a zone name like 'Asia/Tokyo' in /usr/share/zoneinfo.
+if not timezone:match("^[%w_+-]+[%w_+/-]*$") or timezone:find("..", 1, true) then
+    error("builtin:timezone: invalid timezone '" .. timezone .. "'")
+end
+local zoneinfo = "/usr/share/zoneinfo/" .. timezone
+
+execute ("test -f " .. shell_escape(zoneinfo) .. " && timedatectl set-timezone " .. shell_escape(timezone)) {
+    not_if = "case \"$(readlink /etc/localtime)\" in */zoneinfo/" .. shell_escape(timezone) .. ") exit 0 ;; esac; exit 1",
+}
+`
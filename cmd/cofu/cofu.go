@@ -1,16 +1,23 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/kohkimakimoto/cofu/builtin"
 	"github.com/kohkimakimoto/cofu/cofu"
 	"github.com/kohkimakimoto/cofu/ext/agent"
 	"github.com/kohkimakimoto/cofu/ext/fetcher"
+	"github.com/kohkimakimoto/cofu/infra"
 	"github.com/kohkimakimoto/cofu/resource"
 	"github.com/kohkimakimoto/cofu/support/color"
 	"github.com/kohkimakimoto/cofu/support/logutil"
 	"github.com/labstack/gommon/log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -27,20 +34,48 @@ func realMain() (status int) {
 	}()
 
 	// parse flags...
-	var optE, optLogLevel, optVarJson, optVarJsonFile, optConfigFile string
-	var optVersion, optDryRun, optColor, optNoColor, optAgent, optFetch bool
+	var optE, optLogLevel, optVarJson, optConfigFile, optReportFile, optReportFormat string
+	var optRole, optTags, optSkipTags, optPluginPath, optGraphFormat, optSecrets, optSecretsKeyFile, optSourceCacheDir string
+	var optVersion, optDryRun, optCheck, optValidate, optGraph, optPrintVars, optRefreshSources, optInstallDeps, optListBuiltinRecipes, optColor, optNoColor, optAgent, optFetch bool
+	var optVarFiles, optSecretFiles stringsFlag
+	var optParallel int
+	var optCommandTimeout time.Duration
 
 	flag.StringVar(&optE, "e", "", "")
 	flag.StringVar(&optLogLevel, "l", "info", "")
 	flag.StringVar(&optLogLevel, "log-level", "info", "")
 	flag.StringVar(&optVarJson, "var", "", "")
-	flag.StringVar(&optVarJsonFile, "var-file", "", "")
+	flag.Var(&optVarFiles, "var-file", "")
+	flag.BoolVar(&optPrintVars, "print-vars", false, "")
+	flag.Var(&optSecretFiles, "secret-file", "")
+	flag.StringVar(&optSecretsKeyFile, "secrets-key-file", os.Getenv("COFU_SECRETS_KEY_FILE"), "")
+	flag.StringVar(&optSecrets, "secrets", "", "")
+
+	flag.StringVar(&optReportFile, "report-file", "", "")
+	flag.StringVar(&optReportFormat, "report-format", "json", "")
 
 	flag.BoolVar(&optDryRun, "n", false, "")
 	flag.BoolVar(&optDryRun, "dry-run", false, "")
+	flag.BoolVar(&optCheck, "check", false, "")
+	flag.BoolVar(&optValidate, "validate", false, "")
+	flag.BoolVar(&optGraph, "graph", false, "")
+	flag.StringVar(&optGraphFormat, "graph-format", "dot", "")
 	flag.BoolVar(&optVersion, "v", false, "")
 	flag.BoolVar(&optVersion, "version", false, "")
 
+	flag.IntVar(&optParallel, "p", 1, "")
+	flag.IntVar(&optParallel, "parallel", 1, "")
+
+	flag.DurationVar(&optCommandTimeout, "command-timeout", 0, "")
+	flag.StringVar(&optRole, "role", "", "")
+	flag.StringVar(&optTags, "tags", "", "")
+	flag.StringVar(&optSkipTags, "skip-tags", "", "")
+	flag.StringVar(&optPluginPath, "plugin-path", os.Getenv("COFU_PLUGIN_PATH"), "")
+	flag.StringVar(&optSourceCacheDir, "source-cache-dir", "", "")
+	flag.BoolVar(&optRefreshSources, "refresh-sources", false, "")
+	flag.BoolVar(&optInstallDeps, "install-deps", false, "")
+	flag.BoolVar(&optListBuiltinRecipes, "list-builtin-recipes", false, "")
+
 	flag.BoolVar(&optColor, "color", false, "")
 	flag.BoolVar(&optNoColor, "no-color", false, "")
 
@@ -63,13 +98,33 @@ Options:
   -l, -log-level=LEVEL       Log level (error|warn|info|debug). Default is 'info'.
   -h, -help                  Show help
   -n, -dry-run               Runs dry-run mode
+  -check                     Runs dry-run mode and exits with status 2 if any resources would change.
+  -validate                  Checks the recipe without executing any commands and reports all errors.
+  -graph                     Print the resource graph of the recipe without converging.
+  -graph-format=FORMAT       Format of the graph (dot|json). Default is 'dot'.
+  -p, -parallel=N            Converge up to N independent resources concurrently. Default is 1.
+  -command-timeout=DURATION  Kill commands that run longer than DURATION (e.g. '30s', '5m').
+  -role=ROLE[,ROLE...]       Load the run-lists of the roles in 'roles' directory.
+  -tags=TAG[,TAG...]         Only converge resources that have any of the tags.
+  -skip-tags=TAG[,TAG...]    Do not converge resources that have any of the tags.
+  -plugin-path=DIR[:DIR...]  Directories to find resource type plugins. Default is $COFU_PLUGIN_PATH.
+  -source-cache-dir=DIR      Directory to cache the remote recipe sources. Default is '~/.cache/cofu/sources'.
+  -refresh-sources           Fetch the remote recipe sources again even if they are cached.
+  -install-deps              Install the recipe dependencies in cofu-deps.toml and write cofu-deps.lock.
+  -list-builtin-recipes      List the built-in recipes that can be loaded by 'builtin:NAME'.
   -v, -version               Print the version
   -color                     Force ANSI output
   -no-color                  Disable ANSI output
   -a, -agent                 Runs cofu agent
   -c, -config-file=FILE      Load agent config from the FILE
   -var=JSON                  JSON string to input variables.
-  -var-file=JSON_FILE        JSON file to input variables.
+  -var-file=FILE             JSON, YAML or TOML file to input variables. It can be specified multiple times.
+  -print-vars                Print the merged variables as JSON and exit.
+  -secret-file=FILE          Encrypted secret variables file. It can be specified multiple times.
+  -secrets-key-file=FILE     Key file to encrypt and decrypt secrets. Default is $COFU_SECRETS_KEY_FILE.
+  -secrets=COMMAND FILE      Manage the secret variables file (edit|encrypt|decrypt).
+  -report-file=FILE          Write a report of the run to the FILE.
+  -report-format=FORMAT      Format of the report (json). Default is 'json'.
 `)
 	}
 	flag.Parse()
@@ -80,6 +135,16 @@ Options:
 		return 0
 	}
 
+	if optReportFile != "" && optReportFormat != "json" {
+		printError(fmt.Errorf("unsupported report format '%s'", optReportFormat))
+		return 1
+	}
+
+	if optGraph && optGraphFormat != "dot" && optGraphFormat != "json" {
+		printError(fmt.Errorf("unsupported graph format '%s'", optGraphFormat))
+		return 1
+	}
+
 	if optFetch {
 		if err := doFetch(); err != nil {
 			printError(err)
@@ -88,6 +153,19 @@ Options:
 		return 0
 	}
 
+	if optSecrets != "" {
+		if err := doSecrets(optSecrets, flag.Args(), optSecretsKeyFile); err != nil {
+			printError(err)
+			return 1
+		}
+		return 0
+	}
+
+	if optListBuiltinRecipes {
+		listBuiltinRecipes()
+		return 0
+	}
+
 	if optAgent {
 		// run agent
 		if err := agent.Start(optConfigFile); err != nil {
@@ -98,7 +176,7 @@ Options:
 		return 0
 	}
 
-	if optE == "" && flag.NArg() == 0 {
+	if optE == "" && flag.NArg() == 0 && !optPrintVars && !optInstallDeps && optRole == "" {
 		// show usage
 		flag.Usage()
 		return 0
@@ -106,6 +184,7 @@ Options:
 
 	var recipeFile string
 	var recipeContent string
+	var builtinRecipe string
 
 	if optE != "" {
 		recipeContent = optE
@@ -118,6 +197,11 @@ Options:
 		flag.CommandLine.Parse(os.Args[indexOfScript+1:])
 	}
 
+	if strings.HasPrefix(recipeFile, cofu.BuiltinRecipePrefix) {
+		builtinRecipe = strings.TrimPrefix(recipeFile, cofu.BuiltinRecipePrefix)
+		recipeFile = ""
+	}
+
 	// setup the cofu app.
 	app := cofu.NewApp()
 	defer app.Close()
@@ -129,10 +213,11 @@ Options:
 		status = 1
 	}
 
+	logHeader := `${level}${prefix}`
 	logger := log.New("cofu")
 	logger.SetLevel(lv)
 	logger.SetPrefix("")
-	logger.SetHeader(`${level}${prefix}`)
+	logger.SetHeader(logHeader)
 	if optColor {
 		cofu.SetNoColor(false)
 		logger.EnableColor()
@@ -142,14 +227,95 @@ Options:
 		logger.DisableColor()
 	}
 	app.Logger = logger
+	app.LogHeader = logHeader
+	app.Parallel = optParallel
+	app.CommandTimeout = optCommandTimeout
+	app.Tags = splitList(optTags)
+	app.SkipTags = splitList(optSkipTags)
+	app.PluginPaths = filepath.SplitList(optPluginPath)
+	app.Fetcher = cofu.SandboxFetcher
+	app.SourceCacheDir = optSourceCacheDir
+	app.RefreshSources = optRefreshSources
 
 	app.ResourceTypes = resource.ResourceTypes
+	app.BuiltinRecipes = builtin.Recipes
+
+	if optValidate {
+		app.Infra = infra.NewNoExec()
+		app.Validating = true
+	}
+
+	if recipeFile != "" && cofu.IsRemoteSource(recipeFile) {
+		pwd, err := os.Getwd()
+		if err != nil {
+			printError(err)
+			return 1
+		}
+
+		recipeFile, err = app.ResolveRecipeSource(recipeFile, pwd)
+		if err != nil {
+			printError(err)
+			return 1
+		}
+	}
+
+	// the recipe dependencies are next to the main recipe.
+	depsDir := "."
+	if recipeFile != "" {
+		depsDir = filepath.Dir(recipeFile)
+	}
 
-	if optVarJsonFile != "" {
-		if err := app.LoadVariableFromJSONFile(optVarJsonFile); err != nil {
+	if optInstallDeps {
+		if err := app.InstallDeps(depsDir); err != nil {
 			printError(err)
 			return 1
 		}
+		return 0
+	}
+
+	// the recipe given by -e or a built-in recipe doesn't have the dependencies.
+	if recipeFile != "" || optRole != "" {
+		if err := app.LoadDeps(depsDir); err != nil {
+			printError(err)
+			return 1
+		}
+	}
+
+	// load variables. the later ones take precedence over the earlier ones.
+	if recipeFile != "" {
+		if err := app.LoadDefaultVariables(filepath.Dir(recipeFile)); err != nil {
+			printError(err)
+			return 1
+		}
+	}
+
+	// the roles directory is next to the main recipe.
+	roles, err := app.LoadRoles(depsDir, splitList(optRole))
+	if err != nil {
+		printError(err)
+		return 1
+	}
+
+	for _, varFile := range optVarFiles {
+		if err := app.LoadVariableFromFile(varFile); err != nil {
+			printError(err)
+			return 1
+		}
+	}
+
+	if len(optSecretFiles) > 0 {
+		key, err := cofu.LoadSecretsKey(optSecretsKeyFile)
+		if err != nil {
+			printError(err)
+			return 1
+		}
+
+		for _, secretFile := range optSecretFiles {
+			if err := app.LoadSecretVariableFile(secretFile, key); err != nil {
+				printError(err)
+				return 1
+			}
+		}
 	}
 
 	if optVarJson != "" {
@@ -159,33 +325,132 @@ Options:
 		}
 	}
 
+	if err := app.LoadVariableFromEnv(os.Environ()); err != nil {
+		printError(err)
+		return 1
+	}
+
+	if optPrintVars {
+		b, err := json.MarshalIndent(app.Variables(), "", "  ")
+		if err != nil {
+			printError(err)
+			return 1
+		}
+
+		fmt.Println(app.MaskSecrets(string(b)))
+		return 0
+	}
+
 	// initialize app
 	if err := app.Init(); err != nil {
 		printError(err)
 		status = 1
 	}
 
-	if recipeFile != "" {
-		if err := app.LoadRecipeFile(recipeFile); err != nil {
+	// the run-lists of the roles are loaded before the recipe.
+	loadErr := app.LoadRunList(roles)
+	if loadErr == nil {
+		if recipeFile != "" {
+			loadErr = app.LoadRecipeFile(recipeFile)
+		} else if builtinRecipe != "" {
+			loadErr = app.LoadBuiltinRecipe(builtinRecipe)
+		} else if recipeContent != "" {
+			loadErr = app.LoadRecipe(recipeContent)
+		}
+	}
+
+	if optValidate {
+		return validate(app, loadErr)
+	}
+
+	if loadErr != nil {
+		printError(loadErr)
+		return 1
+	}
+
+	if optGraph {
+		graph, err := app.ExportGraph()
+		if err != nil {
 			printError(err)
 			return 1
 		}
-	} else if recipeContent != "" {
-		if err := app.LoadRecipe(recipeContent); err != nil {
+
+		if err := graph.Write(os.Stdout, optGraphFormat); err != nil {
 			printError(err)
 			return 1
 		}
+
+		return status
 	}
 
 	// run converging phase.
-	if err := app.Run(optDryRun); err != nil {
-		printError(err)
+	runErr := app.Run(optDryRun || optCheck)
+
+	if optReportFile != "" {
+		if err := writeReport(app.Report, optReportFile, optReportFormat); err != nil {
+			printError(err)
+			return 1
+		}
+	}
+
+	if runErr != nil {
+		printError(runErr)
 		return 1
 	}
 
+	if optCheck && status == 0 {
+		return checkDrift(app)
+	}
+
 	return status
 }
 
+// validate prints all errors in the recipe and returns the exit status.
+func validate(app *cofu.App, loadErr error) int {
+	errs := app.Validate()
+	if loadErr != nil {
+		errs = append(errs, loadErr)
+	}
+
+	if len(errs) == 0 {
+		app.Logger.Info(color.FgGB("The recipe is valid."))
+		return 0
+	}
+
+	for _, err := range errs {
+		fmt.Fprint(os.Stderr, color.FgRB("%v\n", err))
+	}
+	fmt.Fprint(os.Stderr, color.FgRB("Found %d error(s).\n", len(errs)))
+
+	return 1
+}
+
+// checkDrift prints a summary of the drifted resources and returns the exit status.
+func checkDrift(app *cofu.App) int {
+	drifted := app.DriftedResources()
+	if len(drifted) == 0 {
+		app.Logger.Info(color.FgGB("No drift detected."))
+		return 0
+	}
+
+	app.Logger.Warnf("Detected drift in %d of %d resource(s):", len(drifted), len(app.Resources))
+	for _, r := range drifted {
+		app.Logger.Warnf("  %s", r.Desc())
+	}
+
+	return 2
+}
+
+func writeReport(report *cofu.Report, reportFile, format string) error {
+	f, err := os.Create(reportFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return report.Write(f, format)
+}
+
 func doFetch() error {
 	if len(os.Args) != 4 {
 		return fmt.Errorf("usage: cofu -fetch [src] [dst]")
@@ -202,6 +467,44 @@ func doFetch() error {
 	return nil
 }
 
+// listBuiltinRecipes prints the names and the descriptions of the built-in recipes.
+func listBuiltinRecipes() {
+	names := []string{}
+	for name := range builtin.Recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("%-20s %s\n", cofu.BuiltinRecipePrefix+name, builtin.Description(builtin.Recipes[name]))
+	}
+}
+
+// stringsFlag is a flag that can be specified multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// splitList splits comma separated values.
+func splitList(s string) []string {
+	ret := []string{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			ret = append(ret, v)
+		}
+	}
+
+	return ret
+}
+
 func printError(err interface{}) {
 	fmt.Fprintf(os.Stderr, color.FgRB(cofu.Name+" aborted! "))
 	fmt.Fprintf(os.Stderr, color.FgRB("%v\n", err))
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"github.com/kohkimakimoto/cofu/cofu"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// doSecrets runs the subcommand to manage the encrypted secret variables file.
+func doSecrets(command string, args []string, keyFile string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: cofu -secrets (edit|encrypt|decrypt) FILE")
+	}
+	file := args[0]
+
+	key, err := cofu.LoadSecretsKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "edit":
+		return editSecrets(file, key)
+	case "encrypt":
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		if err := checkSecretsContent(b); err != nil {
+			return err
+		}
+
+		return writeSecrets(file, b, key)
+	case "decrypt":
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err := cofu.DecryptSecrets(b, key)
+		if err != nil {
+			return err
+		}
+
+		_, err = os.Stdout.Write(plaintext)
+		return err
+	default:
+		return fmt.Errorf("unknown secrets command '%s'. It must be edit, encrypt or decrypt.", command)
+	}
+}
+
+// editSecrets decrypts the file into a temporary file, opens it with $EDITOR and encrypts it again.
+func editSecrets(file string, key []byte) error {
+	var plaintext []byte
+	if b, err := ioutil.ReadFile(file); err == nil {
+		plaintext, err = cofu.DecryptSecrets(b, key)
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tmpfile, err := ioutil.TempFile("", "cofu_secrets")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write(plaintext); err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$0"`, tmpfile.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	edited, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		return err
+	}
+
+	if err := checkSecretsContent(edited); err != nil {
+		return err
+	}
+
+	return writeSecrets(file, edited, key)
+}
+
+func checkSecretsContent(b []byte) error {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("secrets must be a YAML (or JSON) mapping: %v", err)
+	}
+
+	return nil
+}
+
+func writeSecrets(file string, plaintext, key []byte) error {
+	encrypted, err := cofu.EncryptSecrets(plaintext, key)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, encrypted, 0600)
+}
@@ -1,7 +1,7 @@
 package cofu
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	fatihColor "github.com/fatih/color"
@@ -15,11 +15,16 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
+// BuiltinRecipePrefix is a prefix of the recipe path to load the built-in recipes like 'builtin:ntp'.
+const BuiltinRecipePrefix = "builtin:"
+
 type App struct {
 	LState               *lua.LState
 	Logger               Logger
@@ -37,6 +42,48 @@ type App struct {
 	LogHeader            string
 	BuiltinRecipes       map[string]string
 	Basepath             string
+	// Parallel is the number of resources that are converged concurrently.
+	Parallel int
+	// Report is a result of the last Run.
+	Report *Report
+	// CommandTimeout is a default timeout of commands. Zero means no timeout.
+	CommandTimeout time.Duration
+	// Tags limits resources to converge to the resources that have any of the tags.
+	Tags []string
+	// SkipTags excludes resources that have any of the tags.
+	SkipTags []string
+	// PluginPaths are directories to find the resource type plugins.
+	PluginPaths []string
+	// Hooks receive the events while converging.
+	Hooks      []Hook
+	hooksMutex sync.Mutex
+	// Validating makes loading recipes record invalid attributes and includes instead of raising errors.
+	// The errors are returned by Validate.
+	Validating       bool
+	validationErrors []error
+	// Fetcher fetches the remote recipe sources of include_recipe and the dependencies.
+	// It is nil by default. Embedding programs set it to fetch the remote sources, like SandboxFetcher.
+	Fetcher SourceFetcher
+	// SourceCacheDir is a directory to cache the fetched sources. Default is 'cofu/sources' in $XDG_CACHE_HOME or '~/.cache'.
+	SourceCacheDir string
+	// RefreshSources makes the sources fetched again even if they are cached.
+	RefreshSources bool
+	fetchedSources map[string]bool
+	// currentRole is the role whose run-list is being loaded.
+	currentRole string
+	// loadedRecipes are the recipes loaded by include_recipe. They are not loaded again by default.
+	loadedRecipes map[string]bool
+	// deps are the vendor directories of the recipe dependencies by the names.
+	deps map[string]string
+	// depsDir is the directory that has the manifest and the lockfile of the dependencies.
+	depsDir string
+	// verifiedDeps are the dependencies whose checksums are verified.
+	verifiedDeps map[string]bool
+	// secrets are the values loaded from the secret variables files. They are masked in the outputs.
+	secrets []string
+	// luaCalls receives functions that use LState while resources are converged in parallel.
+	luaCalls chan func()
+	mutex    sync.Mutex
 }
 
 const LUA_APP_KEY = "*__COFU_APP__"
@@ -60,14 +107,42 @@ func NewApp() *App {
 			"GOARCH": runtime.GOARCH,
 			"GOOS":   runtime.GOOS,
 		},
-		Parent:    nil,
-		Level:     0,
-		LogHeader: defaultLogHeader,
-		Basepath:  "",
+		Parent:         nil,
+		Level:          0,
+		LogHeader:      defaultLogHeader,
+		Basepath:       "",
+		Parallel:       1,
+		fetchedSources: map[string]bool{},
+		loadedRecipes:  map[string]bool{},
 	}
 }
 
 func (app *App) Init() error {
+	// load resource type plugins.
+	if app.Validating {
+		// validating must not run the plugins.
+		resourceTypes, err := unresolvedPluginResourceTypes(app.PluginPaths)
+		if err != nil {
+			return err
+		}
+		for _, resourceType := range resourceTypes {
+			app.Logger.Warnf("Resource type plugin '%s' is not run in validating mode. Its resources are not validated.", resourceType.Name)
+		}
+		app.ResourceTypes = append(app.ResourceTypes, resourceTypes...)
+	} else {
+		plugins, err := DiscoverPlugins(app.PluginPaths)
+		if err != nil {
+			return err
+		}
+		for _, p := range plugins {
+			resourceType, err := p.ResourceType()
+			if err != nil {
+				return err
+			}
+			app.ResourceTypes = append(app.ResourceTypes, resourceType)
+		}
+	}
+
 	// load resource types and define lua functions.
 	for _, resourceType := range app.ResourceTypes {
 		if err := app.loadResourceType(resourceType); err != nil {
@@ -108,6 +183,20 @@ func (app *App) loadResourceType(resourceType *ResourceType) error {
 		return nil
 	}
 
+	// set default diff function it it does not have specific func.
+	// it is set here because the resource types are shared by the child apps that run concurrently.
+	if resourceType.ShowDifferences == nil {
+		resourceType.ShowDifferences = DefaultShowDifferences
+	}
+
+	// action attribute only accepts the defined actions.
+	if attr, ok := resourceType.findAttribute("action").(*StringSliceAttribute); ok && attr.Allowed == nil {
+		for action := range resourceType.Actions {
+			attr.Allowed = append(attr.Allowed, action)
+		}
+		sort.Strings(attr.Allowed)
+	}
+
 	return nil
 }
 
@@ -118,7 +207,10 @@ func (app *App) LoadDefinition(definition *Definition) {
 }
 
 func (app *App) Close() {
-	app.LState.Close()
+	if app.Parent == nil {
+		// a child app shares LState with the parent.
+		app.LState.Close()
+	}
 	for _, f := range app.Tmpfiles {
 		os.RemoveAll(f)
 	}
@@ -128,60 +220,41 @@ func (app *App) Close() {
 	}
 }
 
-func (app *App) LoadVariableFromJSON(v string) error {
-	variable := app.variable
-	err := json.Unmarshal([]byte(v), &variable)
-	if err != nil {
+func (app *App) LoadRecipe(recipeContent string) error {
+	if err := app.LState.DoString(recipeContent); err != nil {
 		return err
 	}
 
-	L := app.LState
-	L.SetGlobal("var", toLValue(L, app.variable))
-
 	return nil
 }
 
-func (app *App) LoadVariableFromJSONFile(jsonFile string) error {
-	b, err := ioutil.ReadFile(jsonFile)
-	if err != nil {
-		return err
-	}
-
-	variable := app.variable
-	err = json.Unmarshal(b, &variable)
-	if err != nil {
-		return err
+func (app *App) LoadRecipeFile(recipeFile string) error {
+	// the recipe may be loaded already by include_recipe or a run-list.
+	key := recipeKey(recipeFile)
+	if app.loadedRecipes[key] {
+		app.Logger.Debugf("Skipped '%s' because it is already loaded.", recipeFile)
+		return nil
 	}
+	app.loadedRecipes[key] = true
 
-	L := app.LState
-	L.SetGlobal("var", toLValue(L, app.variable))
-
-	return nil
+	return loadRecipeFile(recipeFile, app.LState, app)
 }
 
-func (app *App) LoadVariableFromMap(m map[string]interface{}) error {
-	variable := app.variable
-	for k, v := range m {
-		variable[k] = v
+// LoadBuiltinRecipe loads the recipe in BuiltinRecipes by the name.
+func (app *App) LoadBuiltinRecipe(name string) error {
+	content, ok := app.BuiltinRecipes[name]
+	if !ok {
+		return fmt.Errorf("built-in recipe '%s' is not found.", name)
 	}
-	app.variable = variable
 
 	L := app.LState
-	L.SetGlobal("var", toLValue(L, app.variable))
-
-	return nil
-}
-
-func (app *App) LoadRecipe(recipeContent string) error {
-	if err := app.LState.DoString(recipeContent); err != nil {
+	fn, err := L.Load(strings.NewReader(content), BuiltinRecipePrefix+name)
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (app *App) LoadRecipeFile(recipeFile string) error {
-	return loadRecipeFile(recipeFile, app.LState, app)
+	L.Push(fn)
+	return L.PCall(0, lua.MultRet, nil)
 }
 
 func (app *App) RemoveDuplicateDelayedNotification() {
@@ -206,6 +279,9 @@ func (app *App) RemoveDuplicateDelayedNotification() {
 }
 
 func (app *App) EnqueueDelayedNotification(n *Notification) {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+
 	app.DelayedNotifications = append(app.DelayedNotifications, n)
 }
 
@@ -222,7 +298,21 @@ func (app *App) DequeueDelayedNotification() *Notification {
 	return n
 }
 
-func (app *App) Run(dryRun bool) (err error) {
+func (app *App) Run(dryRun bool) error {
+	return app.RunContext(context.Background(), dryRun)
+}
+
+// RunContext converges the resources.
+// If the context is canceled, it stops before the next resource and returns the error of the context.
+func (app *App) RunContext(ctx context.Context, dryRun bool) (err error) {
+	app.Report = NewReport(dryRun)
+	defer func() {
+		app.Report.finish(err)
+		if app.IsRootApp() {
+			app.emit(&Event{Type: EventRunFinished, Error: err})
+		}
+	}()
+
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("%v", e)
@@ -244,32 +334,21 @@ func (app *App) Run(dryRun bool) (err error) {
 		return nil
 	}
 
-	// preprocess for resources
-	for _, r := range app.Resources {
-		// checks required attributes
-		for _, definedAttribute := range r.ResourceType.Attributes {
-			if definedAttribute.IsRequired() {
-				if _, ok := r.Attributes[definedAttribute.GetName()]; !ok {
-					return fmt.Errorf("resource '%s': '%s' attribute is required but it is not set.", r.Desc(), definedAttribute.GetName())
-				}
-			}
-		}
+	if errs := app.prepare(); len(errs) > 0 {
+		return errs[0]
+	}
 
-		// parse and validate notifies attribute
-		if r.GetRawAttribute("notifies") != nil {
-			r.Notifications = r.GetRawAttribute("notifies").([]*Notification)
-			for _, n := range r.Notifications {
-				n.DefinedInResource = r
-				if err := n.Validate(); err != nil {
-					return err
-				}
-			}
-		}
+	app.warnDuplicateResources()
 
-		// set default diff function it it does not have specific func.
-		if r.ResourceType.ShowDifferences == nil {
-			r.ResourceType.ShowDifferences = DefaultShowDifferences
-		}
+	// sort resources by the dependencies
+	graph, err := NewResourceGraph(app.Resources)
+	if err != nil {
+		return err
+	}
+
+	resources, err := graph.Sort()
+	if err != nil {
+		return err
 	}
 
 	if app.IsRootApp() {
@@ -288,15 +367,45 @@ func (app *App) Run(dryRun bool) (err error) {
 
 	logger.Debugf("Loaded %d resource(s).", len(app.Resources))
 
-	for _, r := range app.Resources {
-		err := r.Run("")
-		if err != nil {
+	// filter resources by tags
+	filtered := []*Resource{}
+	for _, r := range resources {
+		r.excluded = app.isExcludedByTags(r)
+		if r.excluded {
+			logger.Debugf("Resource '%s' is excluded by tags.", r.Desc())
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+
+	if len(filtered) < len(resources) {
+		logger.Infof("Excluded %d resource(s) by tags.", len(resources)-len(filtered))
+	}
+	resources = filtered
+
+	if app.Parallel > 1 {
+		if err := app.runResourcesInParallel(ctx, resources, graph); err != nil {
 			return err
 		}
+	} else {
+		for _, r := range resources {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			err := r.Run("")
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	app.RemoveDuplicateDelayedNotification()
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		n := app.DequeueDelayedNotification()
 		if n == nil {
 			break
@@ -315,6 +424,79 @@ func (app *App) Run(dryRun bool) (err error) {
 	return nil
 }
 
+// prepare checks the resources and sets up the notifications before converging.
+// It returns all errors that are found.
+func (app *App) prepare() []error {
+	errs := []error{}
+
+	for _, r := range app.Resources {
+		// checks required attributes
+		for _, definedAttribute := range r.ResourceType.Attributes {
+			if definedAttribute.IsRequired() {
+				_, ok := r.Attributes[definedAttribute.GetName()]
+				_, lazy := r.lazyAttributes[definedAttribute.GetName()]
+				if !ok && !lazy {
+					errs = append(errs, r.validationErrorf("resource '%s': '%s' attribute is required but it is not set.", r.Desc(), definedAttribute.GetName()))
+				}
+			}
+		}
+
+		r.drifted = false
+
+		// parse and validate notifies attribute
+		r.Notifications = []*Notification{}
+		if r.GetRawAttribute("notifies") != nil {
+			r.Notifications = append(r.Notifications, r.GetRawAttribute("notifies").([]*Notification)...)
+			for _, n := range r.Notifications {
+				n.DefinedInResource = r
+				if err := n.Validate(); err != nil {
+					errs = append(errs, r.validationError(err))
+				}
+			}
+		}
+	}
+
+	// subscribes attribute adds notifications to the source resources.
+	for _, r := range app.Resources {
+		if r.GetRawAttribute("subscribes") == nil {
+			continue
+		}
+
+		for _, subscription := range r.GetRawAttribute("subscribes").([]*Notification) {
+			if err := subscription.Validate(); err != nil {
+				errs = append(errs, r.validationError(err))
+				continue
+			}
+
+			sources := app.FindResources(subscription.TargetResourceDesc)
+			if len(sources) == 0 {
+				errs = append(errs, r.validationErrorf("resource '%s': subscribes to '%s' but it is not found.", r.Desc(), subscription.TargetResourceDesc))
+				continue
+			}
+
+			for _, source := range sources {
+				source.Notifications = append(source.Notifications, &Notification{
+					DefinedInResource:  source,
+					Action:             subscription.Action,
+					TargetResourceDesc: r.Desc(),
+					Timing:             subscription.Timing,
+				})
+			}
+		}
+	}
+
+	// validate notifications before converging.
+	for _, r := range app.Resources {
+		for _, n := range r.Notifications {
+			if err := n.ValidateTarget(); err != nil {
+				errs = append(errs, r.validationError(err))
+			}
+		}
+	}
+
+	return errs
+}
+
 func (app *App) RegisterResource(r *Resource) {
 	app.Resources = append(app.Resources, r)
 }
@@ -329,6 +511,58 @@ func (app *App) FindOneResource(desc string) *Resource {
 	return nil
 }
 
+func (app *App) isExcludedByTags(r *Resource) bool {
+	tags := r.GetStringSliceAttribute("tags")
+
+	if len(app.Tags) > 0 && !containsAnyString(tags, app.Tags) {
+		return true
+	}
+
+	return containsAnyString(tags, app.SkipTags)
+}
+
+// warnDuplicateResources outputs warnings for resources that have the same description.
+// Notifications to them are only sent to the first one.
+func (app *App) warnDuplicateResources() {
+	counts := map[string]int{}
+	descs := []string{}
+	for _, r := range app.Resources {
+		if counts[r.Desc()] == 0 {
+			descs = append(descs, r.Desc())
+		}
+		counts[r.Desc()]++
+	}
+
+	for _, desc := range descs {
+		if counts[desc] > 1 {
+			app.Logger.Warnf("Resource '%s' is defined %d times. Notifications to it are sent to the first one.", desc, counts[desc])
+		}
+	}
+}
+
+// DriftedResources returns the resources that differed from the current state in the last Run.
+func (app *App) DriftedResources() []*Resource {
+	resources := []*Resource{}
+	for _, r := range app.Resources {
+		if r.drifted {
+			resources = append(resources, r)
+		}
+	}
+
+	return resources
+}
+
+func (app *App) FindResources(desc string) []*Resource {
+	resources := []*Resource{}
+	for _, r := range app.Resources {
+		if r.Desc() == desc {
+			resources = append(resources, r)
+		}
+	}
+
+	return resources
+}
+
 func (app *App) SendContentToTempfile(content []byte) (string, error) {
 	tmpFile, err := ioutil.TempFile(app.Tmpdir, "")
 	if err != nil {
@@ -346,7 +580,7 @@ func (app *App) SendContentToTempfile(content []byte) (string, error) {
 		return "", err
 	}
 
-	app.Tmpfiles = append(app.Tmpfiles, tmpFile.Name())
+	app.addTmpfile(tmpFile.Name())
 
 	return tmpFile.Name(), nil
 }
@@ -373,10 +607,91 @@ func (app *App) SendDirectoryToTempDirectory(src string) (string, error) {
 		return "", err
 	}
 
-	app.Tmpfiles = append(app.Tmpfiles, tmpDir)
+	app.addTmpfile(tmpDir)
 	return tmpDir2, nil
 }
 
+func (app *App) addTmpfile(path string) {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+
+	app.Tmpfiles = append(app.Tmpfiles, path)
+}
+
+// CallLState calls fn with the LState.
+// LState is not goroutine safe. So while resources are converged in parallel,
+// fn is called on the goroutine that runs App.Run.
+func (app *App) CallLState(fn func(L *lua.LState) error) error {
+	if app.luaCalls == nil {
+		return fn(app.LState)
+	}
+
+	done := make(chan error, 1)
+	app.luaCalls <- func() {
+		defer func() {
+			if e := recover(); e != nil {
+				done <- fmt.Errorf("%v", e)
+			}
+		}()
+		done <- fn(app.LState)
+	}
+
+	return <-done
+}
+
+// newChildApp creates an app to converge the resources defined in the actions of the composite resource.
+func (app *App) newChildApp(r *Resource) *App {
+	child := &App{
+		LState:               app.LState,
+		Logger:               r.Logger(),
+		ResourceTypes:        app.ResourceTypes,
+		ResourceTypesMap:     app.ResourceTypesMap,
+		Resources:            []*Resource{},
+		DelayedNotifications: []*Notification{},
+		Infra:                app.Infra,
+		Tmpdir:               app.Tmpdir,
+		Tmpfiles:             []string{},
+		variable:             app.variable,
+		Parent:               app,
+		Level:                app.Level + 1,
+		LogHeader:            app.LogHeader,
+		BuiltinRecipes:       app.BuiltinRecipes,
+		Basepath:             r.Basepath,
+		Parallel:             1,
+		CommandTimeout:       app.CommandTimeout,
+		loadedRecipes:        map[string]bool{},
+		currentRole:          r.Role,
+		// the child resources call LState through the goroutine of the parent in parallel mode.
+		luaCalls: app.luaCalls,
+	}
+	child.Logger.SetPrefix(GenLogIndent(child.Level))
+
+	return child
+}
+
+// callWithApp calls the function with the app registered into the LState.
+// The resources defined in the function are registered into the app.
+func (app *App) callWithApp(fn *lua.LFunction, args ...lua.LValue) error {
+	L := app.LState
+
+	orgApp := L.GetGlobal(LUA_APP_KEY)
+	defer L.SetGlobal(LUA_APP_KEY, orgApp)
+
+	ud := L.NewUserData()
+	ud.Value = app
+	L.SetGlobal(LUA_APP_KEY, ud)
+
+	return L.CallByParam(lua.P{
+		Fn:      fn,
+		NRet:    0,
+		Protect: true,
+	}, args...)
+}
+
+func (app *App) isParallel() bool {
+	return app.luaCalls != nil
+}
+
 func (app *App) IsRootApp() bool {
 	return app.Level == 0
 }
@@ -414,8 +729,21 @@ func toLValue(L *lua.LState, value interface{}) lua.LValue {
 		return lua.LBool(converted)
 	case float64:
 		return lua.LNumber(converted)
+	case int:
+		return lua.LNumber(converted)
 	case string:
 		return lua.LString(converted)
+	case *Integer:
+		if converted.Nil() {
+			return lua.LNil
+		}
+		return lua.LNumber(converted.V)
+	case []string:
+		arr := L.CreateTable(len(converted), 0)
+		for _, item := range converted {
+			arr.Append(lua.LString(item))
+		}
+		return arr
 	case []interface{}:
 		arr := L.CreateTable(len(converted), 0)
 		for _, item := range converted {
@@ -1,8 +1,18 @@
 package cofu
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/yuin/gopher-lua"
 )
 
 func TestAppJustRun(t *testing.T) {
@@ -75,3 +85,1157 @@ func TestSendFileToTempfile(t *testing.T) {
 		t.Errorf("invalid data: %s", string(b2))
 	}
 }
+
+func newTestRecordingResourceType(executed *[]string) *ResourceType {
+	var mutex sync.Mutex
+
+	return &ResourceType{
+		Name: "test",
+		Attributes: []Attribute{
+			&StringSliceAttribute{
+				Name:     "action",
+				Default:  []string{"run"},
+				Required: true,
+			},
+		},
+		PreAction: func(r *Resource) error {
+			r.Attributes["executed"] = true
+			return nil
+		},
+		SetCurrentAttributesFunc: func(r *Resource) error {
+			r.CurrentAttributes["executed"] = false
+			return nil
+		},
+		Actions: map[string]ResourceAction{
+			"run": func(r *Resource) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				*executed = append(*executed, r.Desc())
+				return nil
+			},
+		},
+	}
+}
+
+func TestAppRunDependsOn(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {
+    depends_on = {"test[c]", "test[b]"},
+}
+test "b" {}
+test "c" {
+    depends_on = "test[b]",
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"test[b]", "test[c]", "test[a]"}
+	if strings.Join(executed, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected order %v", executed)
+	}
+}
+
+func TestAppRunDependsOnCycle(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {
+    depends_on = "test[b]",
+}
+test "b" {
+    depends_on = "test[a]",
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	err := app.Run(false)
+	if err == nil {
+		t.Fatal("should raise error")
+	}
+
+	if !strings.Contains(err.Error(), "test[a] -> test[b] -> test[a]") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if len(executed) != 0 {
+		t.Errorf("should not run any resources but %v", executed)
+	}
+}
+
+func TestAppRunParallel(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.Parallel = 4
+
+	resourceType := newTestRecordingResourceType(&executed)
+	resourceType.PreAction = func(r *Resource) error {
+		r.Attributes["executed"] = true
+		return r.CallLState(func(L *lua.LState) error {
+			return L.DoString(`count = (count or 0) + 1`)
+		})
+	}
+	app.ResourceTypes = []*ResourceType{resourceType}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {}
+test "b" {}
+test "c" {
+    depends_on = {"test[a]", "test[b]"},
+}
+test "d" {
+    depends_on = "test[c]",
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(executed) != 4 || executed[2] != "test[c]" || executed[3] != "test[d]" {
+		t.Errorf("unexpected order %v", executed)
+	}
+
+	if count := app.LState.GetGlobal("count"); count.String() != "4" {
+		t.Errorf("unexpected count %v", count)
+	}
+}
+
+func TestAppRunParallelImmediateNotification(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.Parallel = 2
+
+	bRunning := make(chan struct{})
+	notified := make(chan struct{})
+	var once sync.Once
+
+	resourceType := newTestRecordingResourceType(&executed)
+	run := resourceType.Actions["run"]
+	resourceType.Actions["run"] = func(r *Resource) error {
+		switch r.Name {
+		case "a":
+			// notify 'b' while it is running.
+			<-bRunning
+		case "b":
+			// 'b' keeps running until 'c', that runs after 'a' notified, finishes.
+			once.Do(func() {
+				close(bRunning)
+				<-notified
+			})
+		case "c":
+			defer close(notified)
+		}
+		return run(r)
+	}
+	app.ResourceTypes = []*ResourceType{resourceType}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {
+    notifies = {"run", "test[b]", "immediately"},
+}
+test "b" {}
+test "c" {
+    depends_on = "test[a]",
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the notification runs 'b' again after it finished, instead of running it concurrently.
+	if strings.Join(executed, ",") != "test[a],test[c],test[b],test[b]" {
+		t.Errorf("unexpected order %v", executed)
+	}
+}
+func TestAppRunReport(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {}
+test "b" {
+    only_if = "false",
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	report := app.Report
+	if len(report.Resources) != 2 {
+		t.Fatalf("unexpected resources %v", report.Resources)
+	}
+
+	a := report.Resources[0]
+	if a.Resource != "test[a]" || !a.Updated || len(a.Differences) != 1 || a.Differences[0].Attribute != "executed" {
+		t.Errorf("unexpected report %+v", a)
+	}
+
+	b := report.Resources[1]
+	if b.Resource != "test[b]" || b.Updated || !b.Skipped || b.SkippedReason != "only_if" {
+		t.Errorf("unexpected report %+v", b)
+	}
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), `"resource": "test[a]"`) {
+		t.Errorf("unexpected json %s", buf.String())
+	}
+}
+
+func TestAppRunRetriesAndIgnoreFailure(t *testing.T) {
+	executed := []string{}
+	failures := map[string]int{
+		"test[a]": 2,
+		"test[b]": 10,
+	}
+
+	app := NewApp()
+	defer app.Close()
+
+	resourceType := newTestRecordingResourceType(&executed)
+	run := resourceType.Actions["run"]
+	resourceType.Actions["run"] = func(r *Resource) error {
+		if failures[r.Desc()] > 0 {
+			failures[r.Desc()]--
+			r.MustRunCommand("echo failed && false")
+		}
+		return run(r)
+	}
+	app.ResourceTypes = []*ResourceType{resourceType}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {
+    retries = 2,
+    retry_delay = 0,
+}
+test "b" {
+    retries = 1,
+    retry_delay = 0,
+    ignore_failure = true,
+}
+test "c" {}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	differences := map[string]int{}
+	app.AddHook(func(e *Event) {
+		if e.Type == EventDifference {
+			differences[e.Resource.Desc()]++
+		}
+	})
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if differences["test[a]"] != 1 || differences["test[b]"] != 1 {
+		t.Errorf("the difference events must be emitted once even if the action is retried but %v", differences)
+	}
+
+	if strings.Join(executed, ",") != "test[a],test[c]" {
+		t.Errorf("unexpected executed resources %v", executed)
+	}
+
+	a := app.Report.Resources[0]
+	if len(a.Differences) != 1 {
+		t.Errorf("the differences must be reported once even if the action is retried but %d", len(a.Differences))
+	}
+
+	if failures["test[b]"] != 8 {
+		t.Errorf("test[b] should be tried 2 times but %d", 10-failures["test[b]"])
+	}
+
+	b := app.Report.Resources[1]
+	if !b.FailureIgnored || b.Error == "" {
+		t.Errorf("unexpected report %+v", b)
+	}
+}
+
+func TestAppRunCommandTimeout(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+
+	resourceType := newTestRecordingResourceType(&executed)
+	resourceType.Actions["run"] = func(r *Resource) error {
+		r.MustRunCommand("sleep 10 | sleep 10")
+		return nil
+	}
+	app.ResourceTypes = []*ResourceType{resourceType}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {
+    timeout = 1,
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err := app.Run(false)
+	if err == nil {
+		t.Fatal("should raise error")
+	}
+
+	if !strings.Contains(err.Error(), "test[a]: command 'sleep 10 | sleep 10' timed out") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("the command should be killed")
+	}
+}
+
+func TestAppRunCommandTimeoutInGuardAndVerify(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {
+    only_if = "sleep 10",
+    timeout = 1,
+    ignore_failure = true,
+}
+test "b" {
+    verify = {"sleep 10"},
+    timeout = 1,
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	err := app.Run(false)
+	if err == nil || !strings.Contains(err.Error(), "test[b]: command 'sleep 10' timed out") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if strings.Join(executed, ",") != "test[b]" {
+		t.Errorf("unexpected executed resources %v", executed)
+	}
+
+	a := app.Report.Resources[0]
+	if !a.FailureIgnored || !strings.Contains(a.Error, "timed out") {
+		t.Errorf("the timeout of the guard must be ignored by ignore_failure but %+v", a)
+	}
+
+	b := app.Report.Resources[1]
+	if !strings.Contains(b.Error, "timed out") {
+		t.Errorf("the timeout of verify must be reported but %+v", b)
+	}
+}
+
+func TestAppRunSubscribes(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "delayed" {
+    action = "nothing",
+    subscribes = {"run", "test[a]"},
+}
+test "immediately" {
+    action = "nothing",
+    subscribes = {{"run", "test[a]", "immediately"}},
+}
+test "a" {}
+test "b" {}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"test[a]", "test[immediately]", "test[b]", "test[delayed]"}
+	if strings.Join(executed, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected order %v", executed)
+	}
+}
+
+func TestAppRunValidatesNotifications(t *testing.T) {
+	cases := []struct {
+		recipe string
+		err    string
+	}{
+		{
+			recipe: `
+test "a" {
+    notifies = {"run", "test[unknown]"},
+}
+`,
+			err: "notification target 'test[unknown]' is not found.",
+		},
+		{
+			recipe: `
+test "a" {
+    notifies = {"restart", "test[b]"},
+}
+test "b" {}
+`,
+			err: "'restart' is not supported action of the notification target 'test[b]'.",
+		},
+	}
+
+	for _, c := range cases {
+		executed := []string{}
+
+		app := NewApp()
+		app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+		if err := app.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := app.LoadRecipe(c.recipe); err != nil {
+			t.Fatal(err)
+		}
+
+		err := app.Run(false)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("unexpected error %v", err)
+		}
+
+		if len(executed) != 0 {
+			t.Errorf("should not run any resources but %v", executed)
+		}
+
+		app.Close()
+	}
+}
+
+func TestAppRunGuardFunctions(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {
+    only_if = function(r)
+        return r.description == "run"
+    end,
+    description = "run",
+}
+test "b" {
+    only_if = function() return false end,
+}
+test "c" {
+    not_if = function() return true end,
+}
+test "d" {
+    not_if = function() return nil end,
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(executed, ",") != "test[a],test[d]" {
+		t.Errorf("unexpected executed resources %v", executed)
+	}
+}
+
+func TestAppRunTags(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.Tags = []string{"config"}
+	app.SkipTags = []string{"slow"}
+
+	stdout := new(bytes.Buffer)
+	app.Logger.SetOutput(stdout)
+
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "package" {
+    tags = "packages",
+}
+test "config" {
+    tags = {"config"},
+    notifies = {"run", "test[package]"},
+}
+test "slow_config" {
+    tags = {"config", "slow"},
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(executed, ",") != "test[config]" {
+		t.Errorf("unexpected executed resources %v", executed)
+	}
+
+	if !strings.Contains(stdout.String(), "the target resource is excluded by tags") {
+		t.Errorf("unexpected output %s", stdout.String())
+	}
+}
+
+func TestAppRunDriftedResources(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {}
+test "b" {
+    only_if = "false",
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(executed) != 0 {
+		t.Errorf("dry-run must not execute actions but executed %v", executed)
+	}
+
+	drifted := app.DriftedResources()
+	if len(drifted) != 1 || drifted[0].Desc() != "test[a]" {
+		t.Errorf("unexpected drifted resources %v", drifted)
+	}
+
+	if !app.Report.Resources[0].Drifted {
+		t.Errorf("report must have drifted resource")
+	}
+}
+
+func TestAppRunLuaResourceType(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+resource_type "app" {
+    attributes = {
+        app_name = { type = "string", default_name = true },
+        port = { type = "integer", default = 8080 },
+        enabled = "bool",
+        params = "map",
+    },
+    default_action = "deploy",
+    actions = {
+        deploy = function(r)
+            test (r.app_name .. ":" .. r.port) {}
+        end,
+        remove = function(r)
+        end,
+    },
+}
+
+app "web" {
+    notifies = {"run", "test[notified]"},
+}
+
+app "worker" {
+    action = "remove",
+    notifies = {"run", "test[not_notified]"},
+}
+
+test "notified" {
+    action = "nothing",
+}
+
+test "not_notified" {
+    action = "nothing",
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(executed, ",") != "test[web:8080],test[notified]" {
+		t.Errorf("unexpected executed resources %v", executed)
+	}
+
+	if len(app.Resources) != 4 {
+		t.Errorf("resources in the actions must not be registered into the parent app: %d", len(app.Resources))
+	}
+
+	reported := []string{}
+	for _, rr := range app.Report.Resources {
+		reported = append(reported, rr.Resource)
+	}
+	if strings.Join(reported, ",") != "app[web],test[web:8080],app[worker],test[notified]" {
+		t.Errorf("the report must have the resources in the actions: %v", reported)
+	}
+
+	// the actions are evaluated in dry-run mode too.
+	executed = executed[:0]
+	if err := app.Run(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(executed) != 0 {
+		t.Errorf("dry-run must not execute actions but executed %v", executed)
+	}
+
+	drifted := app.DriftedResources()
+	if len(drifted) != 1 || drifted[0].Desc() != "app[web]" {
+		t.Errorf("unexpected drifted resources %v", drifted)
+	}
+}
+
+func TestAppRunLuaResourceTypeInParallel(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.Parallel = 2
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+resource_type "pair" {
+    attributes = {
+        label = { type = "string", default_name = true },
+    },
+    actions = {
+        run = function(r)
+            test (r.label .. "-1") {}
+            test (r.label .. "-2") {
+                only_if = function() return true end,
+            }
+        end,
+    },
+}
+
+pair "a" {}
+pair "b" {}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(executed)
+	if strings.Join(executed, ",") != "test[a-1],test[a-2],test[b-1],test[b-2]" {
+		t.Errorf("unexpected executed resources %v", executed)
+	}
+
+	if len(app.Report.Resources) != 6 {
+		t.Errorf("the report must have the resources in the actions: %d", len(app.Report.Resources))
+	}
+}
+
+func TestResourceIndexOfUnsetAttribute(t *testing.T) {
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&[]string{})}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the resource types declared in Lua read the default values.
+	if err := app.LoadRecipe(`
+local t = test "a"
+assert(t.description == nil, "description of test[a] must be nil")
+
+resource_type "app" {
+    attributes = {
+        port = { type = "integer", default = 8080 },
+    },
+    actions = {
+        deploy = function(r) end,
+    },
+}
+
+local a = app "web"
+assert(a.port == 8080, "port of app[web] must be the default value")
+`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppLuaResourceTypeErrors(t *testing.T) {
+	cases := map[string]string{
+		`resource_type "x" {}`: "'actions' must be a table",
+		`resource_type "x" { actions = { a = function() end, b = function() end } }`:           "'default_action' is required",
+		`resource_type "x" { attributes = { a = "float" }, actions = { a = function() end } }`: "unsupported type 'float'",
+		`resource_type "test" { actions = { a = function() end } }`:                            "Already defined resource type 'test'",
+	}
+
+	for recipe, expected := range cases {
+		app := NewApp()
+		app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&[]string{})}
+		if err := app.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		err := app.LoadRecipe(recipe)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q but got %v", recipe, expected, err)
+		}
+		app.Close()
+	}
+}
+
+func TestAppRunLazyAttributes(t *testing.T) {
+	values := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{
+		{
+			Name: "kv",
+			Attributes: []Attribute{
+				&StringSliceAttribute{
+					Name:     "action",
+					Default:  []string{"set"},
+					Required: true,
+				},
+				&StringAttribute{
+					Name:     "value",
+					Required: true,
+				},
+				&IntegerAttribute{
+					Name: "num",
+				},
+			},
+			PreAction: func(r *Resource) error {
+				r.Attributes["executed"] = true
+				return nil
+			},
+			SetCurrentAttributesFunc: func(r *Resource) error {
+				r.CurrentAttributes["executed"] = false
+				return nil
+			},
+			Actions: map[string]ResourceAction{
+				"set": func(r *Resource) error {
+					value := r.GetStringAttribute("value")
+					values = append(values, value)
+
+					// the value is available for the later resources.
+					return r.CallLState(func(L *lua.LState) error {
+						L.SetGlobal("produced", lua.LString(value))
+						return nil
+					})
+				},
+			},
+		},
+	}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+kv "first" {
+    value = "hello",
+}
+
+kv "second" {
+    value = function(r)
+        return produced .. " world"
+    end,
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(values, ",") != "hello,hello world" {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	// errors are reported against the resource.
+	if err := app.LoadRecipe(`
+kv "third" {
+    value = "x",
+    num = function(r)
+        return "not a number"
+    end,
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err == nil || !strings.Contains(err.Error(), "kv[third]: failed to evaluate 'num' attribute") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestAppLoadRecipeValidatesAttributes(t *testing.T) {
+	cases := map[string]string{
+		`test "a" { action = "foo" }`:                             `test[a]: 'action' attribute must be one of 'nothing', 'run' but got 'foo'.`,
+		`test "a" { ignore_failure = "yes" }`:                     `test[a]: 'ignore_failure' attribute must be a boolean but got string.`,
+		`test "a" { depends_on = {"test[b]", 1} }`:                `test[a]: 'depends_on' attribute must be an array of strings but got number at index 2.`,
+		`test "a" { retries = "many" }`:                           `test[a]: 'retries' attribute must be an integer but got 'many'.`,
+		`test "a" { timeout = -1 }`:                               `test[a]: 'timeout' attribute must be greater than or equal to 0 but got -1.`,
+		`test "a" { user = function() return "root" end }`:        `test[a]: 'user' attribute must be a string but got function.`,
+		`test "a" { notifies = {"run", "test[b]", "sometimes"} }`: `test[a]: 'notifies' attribute is invalid: 'sometimes' is not valid notification timing.`,
+		`local a = test "a"
+a.only_if = {}`: `<string>:3: test[a]: 'only_if' attribute must be a command string or a function but got table.`,
+		`test "a" { unknown = true }`: `test[a]: Invalid attribute name 'unknown'.`,
+	}
+
+	for recipe, expected := range cases {
+		app := NewApp()
+		app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&[]string{})}
+		if err := app.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		err := app.LoadRecipe("\n" + recipe)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q but got %v", recipe, expected, err)
+		}
+
+		// errors have the location in the recipe.
+		if err != nil && !strings.Contains(err.Error(), "<string>:") {
+			t.Errorf("%s: error must have the location but got %v", recipe, err)
+		}
+		app.Close()
+	}
+}
+
+func TestAppExportGraph(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "b" {
+    depends_on = "test[a]",
+    notifies = {"run", "test[c]", "immediately"},
+}
+test "a" {}
+test "c" {}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	graph, err := app.ExportGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := graph.Resources[0]
+	if b.Resource != "test[b]" || b.Order != 2 || b.Recipe != "<string>" || b.Line != 2 {
+		t.Errorf("unexpected resource %+v", b)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := graph.Write(buf, "dot"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`r0 [label="2. test[b]"];`,
+		`r1 -> r0;`,
+		`r0 -> r2 [style=dashed, label="run (immediately)"];`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("the graph must contain %s but got\n%s", expected, buf.String())
+		}
+	}
+
+	if len(executed) != 0 {
+		t.Errorf("the graph must not converge resources")
+	}
+}
+
+func TestAppRunSensitiveAttributes(t *testing.T) {
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{
+		{
+			Name: "test",
+			Attributes: []Attribute{
+				&StringSliceAttribute{
+					Name:     "action",
+					Default:  []string{"run"},
+					Required: true,
+				},
+				&StringAttribute{
+					Name:      "password",
+					Sensitive: true,
+				},
+				&StringAttribute{
+					Name: "comment",
+				},
+			},
+			SetCurrentAttributesFunc: func(r *Resource) error {
+				r.CurrentAttributes["password"] = "old"
+				r.CurrentAttributes["comment"] = "old"
+				return nil
+			},
+			Actions: map[string]ResourceAction{
+				"run": func(r *Resource) error {
+					return nil
+				},
+			},
+		},
+	}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+test "a" {
+    password = "hunter2",
+    comment = "hello",
+}
+test "b" {
+    comment = "hello",
+    sensitive = true,
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	hooked := []string{}
+	app.AddHook(func(e *Event) {
+		if e.Type == EventDifference {
+			hooked = append(hooked, fmt.Sprintf("%s.%s: %v -> %v", e.Resource.Desc(), e.Attribute, e.Current, e.Desired))
+		}
+	})
+
+	if err := app.Run(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hooked) != 3 {
+		t.Errorf("unexpected difference events %v", hooked)
+	}
+	for _, h := range hooked {
+		if strings.Contains(h, "hunter2") || strings.HasPrefix(h, "test[b]") && strings.Contains(h, "hello") {
+			t.Errorf("the hooks must receive the masked values but got %s", h)
+		}
+	}
+
+	expected := map[string]string{
+		"test[a].comment":  "old -> hello",
+		"test[a].password": "******** -> ********",
+		"test[b].comment":  "******** -> ********",
+	}
+	for _, rr := range app.Report.Resources {
+		for _, d := range rr.Differences {
+			key := rr.Resource + "." + d.Attribute
+			if actual := fmt.Sprintf("%v -> %v", d.Current, d.Desired); expected[key] != actual {
+				t.Errorf("unexpected difference of %s: %s", key, actual)
+			}
+		}
+	}
+
+	a := app.FindOneResource("test[a]")
+	if redacted := a.Redact("usermod -p 'hunter2' hello"); redacted != "usermod -p '********' hello" {
+		t.Errorf("unexpected redacted string %s", redacted)
+	}
+}
+
+func TestAppIncludeBuiltinRecipe(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	app.BuiltinRecipes = map[string]string{
+		"base": `test "base" {}`,
+	}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`include_recipe "builtin:base"`); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(app.Resources) != 1 || app.Resources[0].Location != "builtin:base:1:" {
+		t.Errorf("unexpected resources %v", app.Resources)
+	}
+
+	if err := app.LoadRecipe(`include_recipe "builtin:unknown"`); err == nil || !strings.Contains(err.Error(), "built-in recipe 'unknown' is not found.") {
+		t.Errorf("unknown built-in recipe must be an error but got %v", err)
+	}
+}
+
+func TestAppIncludeRecipeOnceAndGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_include_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"base.lua":        `test "base" {}`,
+		"roles/web.lua":   `include_recipe "../base.lua"` + "\n" + `test "web" {}`,
+		"roles/db.lua":    `include_recipe "../base"` + "\n" + `test "db" {}`,
+		"conf.d/b.lua":    `test "conf_b" {}`,
+		"conf.d/a.lua":    `test "conf_a" {}`,
+		"conf.d/c.txt":    `test "conf_c" {}`,
+		"again/again.lua": `include_recipe("../base.lua", {once = false})`,
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	recipe := filepath.Join(dir, "recipe.lua")
+	if err := ioutil.WriteFile(recipe, []byte(`
+include_recipe "roles/web.lua"
+include_recipe "roles/db.lua"
+include_recipe "conf.d/*.lua"
+include_recipe "conf.d/*.lua"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipeFile(recipe); err != nil {
+		t.Fatal(err)
+	}
+
+	descs := []string{}
+	for _, r := range app.Resources {
+		descs = append(descs, r.Desc())
+	}
+
+	expected := []string{"test[base]", "test[web]", "test[db]", "test[conf_a]", "test[conf_b]"}
+	if strings.Join(descs, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected resources %v", descs)
+	}
+
+	if err := app.LoadRecipe(`include_recipe "` + filepath.Join(dir, "again/again.lua") + `"`); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Resources) != 6 || app.Resources[5].Desc() != "test[base]" {
+		t.Errorf("the recipe must be loaded again with once = false")
+	}
+
+	logs := new(bytes.Buffer)
+	app.Logger.SetOutput(logs)
+	if err := app.LoadRecipe(`include_recipe "` + filepath.Join(dir, "missing/*.lua") + `"`); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "no recipes match") {
+		t.Errorf("the glob that matches no recipes must be warned: %s", logs.String())
+	}
+}
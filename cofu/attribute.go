@@ -3,16 +3,25 @@ package cofu
 import (
 	"fmt"
 	"github.com/yuin/gopher-lua"
+	"regexp"
 	"strconv"
+	"strings"
 )
 
+// minZero is the lower bound of the common attributes that must not be negative.
+var minZero = 0
+
 var CommonAttributes = []Attribute{
-	&StringAttribute{
+	&GuardAttribute{
 		Name: "only_if",
 	},
-	&StringAttribute{
+	&GuardAttribute{
 		Name: "not_if",
 	},
+	&StringSliceAttribute{
+		Name:    "depends_on",
+		Default: nil,
+	},
 	&StringAttribute{
 		Name: "user",
 	},
@@ -23,6 +32,10 @@ var CommonAttributes = []Attribute{
 		Name:    "notifies",
 		Default: nil,
 	},
+	&NotifiesAttribute{
+		Name:    "subscribes",
+		Default: nil,
+	},
 	&StringSliceAttribute{
 		Name:    "verify",
 		Default: nil,
@@ -30,6 +43,30 @@ var CommonAttributes = []Attribute{
 	&StringAttribute{
 		Name: "description",
 	},
+	&StringSliceAttribute{
+		Name:    "tags",
+		Default: nil,
+	},
+	&IntegerAttribute{
+		Name: "retries",
+		Min:  &minZero,
+	},
+	&IntegerAttribute{
+		Name: "retry_delay",
+		Min:  &minZero,
+	},
+	&BoolAttribute{
+		Name:    "ignore_failure",
+		Default: false,
+	},
+	&IntegerAttribute{
+		Name: "timeout",
+		Min:  &minZero,
+	},
+	&BoolAttribute{
+		Name:    "sensitive",
+		Default: false,
+	},
 }
 
 type Attribute interface {
@@ -38,6 +75,26 @@ type Attribute interface {
 	HasDefault() bool
 	GetDefault() interface{}
 	ToGoValue(v lua.LValue) interface{}
+	// Validate checks the value set in a recipe before it is converted by ToGoValue.
+	Validate(v lua.LValue) error
+}
+
+func typeError(name string, expected string, lv lua.LValue) error {
+	return fmt.Errorf("'%s' attribute must be %s but got %s.", name, expected, lv.Type().String())
+}
+
+func validateAllowedString(name string, allowed []string, s string) error {
+	if allowed == nil {
+		return nil
+	}
+
+	for _, a := range allowed {
+		if a == s {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("'%s' attribute must be one of '%s' but got '%s'.", name, strings.Join(allowed, "', '"), s)
 }
 
 // StringAttribute
@@ -47,6 +104,12 @@ type StringAttribute struct {
 	Default  string
 	// If DefaultName is true, it uses name as default value.
 	DefaultName bool
+	// Allowed limits the value to one of them.
+	Allowed []string
+	// Pattern is a regular expression that the value must match.
+	Pattern *regexp.Regexp
+	// If Sensitive is true, the value is not shown in the outputs.
+	Sensitive bool
 }
 
 func (attr *StringAttribute) GetName() string {
@@ -69,6 +132,10 @@ func (attr *StringAttribute) IsDefaultName() bool {
 	return attr.DefaultName
 }
 
+func (attr *StringAttribute) IsSensitive() bool {
+	return attr.Sensitive
+}
+
 func (attr *StringAttribute) ToGoValue(lv lua.LValue) interface{} {
 	v := toGoValue(lv)
 	if fv, ok := v.(float64); ok {
@@ -78,6 +145,27 @@ func (attr *StringAttribute) ToGoValue(lv lua.LValue) interface{} {
 	return v
 }
 
+func (attr *StringAttribute) Validate(lv lua.LValue) error {
+	switch lv.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LString, lua.LNumber:
+	default:
+		return typeError(attr.Name, "a string", lv)
+	}
+
+	s := attr.ToGoValue(lv).(string)
+	if err := validateAllowedString(attr.Name, attr.Allowed, s); err != nil {
+		return err
+	}
+
+	if attr.Pattern != nil && !attr.Pattern.MatchString(s) {
+		return fmt.Errorf("'%s' attribute must match '%s' but got '%s'.", attr.Name, attr.Pattern.String(), s)
+	}
+
+	return nil
+}
+
 // StringSliceAttribute
 type StringSliceAttribute struct {
 	Name     string
@@ -85,6 +173,8 @@ type StringSliceAttribute struct {
 	Default  []string
 	// If DefaultName is true, it uses name as default value.
 	DefaultName bool
+	// Allowed limits each value to one of them.
+	Allowed []string
 }
 
 func (attr *StringSliceAttribute) GetName() string {
@@ -121,11 +211,42 @@ func (attr *StringSliceAttribute) ToGoValue(v lua.LValue) interface{} {
 	}
 }
 
+func (attr *StringSliceAttribute) Validate(lv lua.LValue) error {
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LString:
+		return validateAllowedString(attr.Name, attr.Allowed, string(v))
+	case *lua.LTable:
+		maxn := v.MaxN()
+		if v.Len() != maxn || countTableEntries(v) != maxn {
+			return fmt.Errorf("'%s' attribute must be an array of strings.", attr.Name)
+		}
+
+		for i := 1; i <= maxn; i++ {
+			s, ok := v.RawGetInt(i).(lua.LString)
+			if !ok {
+				return fmt.Errorf("'%s' attribute must be an array of strings but got %s at index %d.", attr.Name, v.RawGetInt(i).Type().String(), i)
+			}
+
+			if err := validateAllowedString(attr.Name, attr.Allowed, string(s)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return typeError(attr.Name, "a string or an array of strings", lv)
+	}
+}
+
 // MapAttribute
 type MapAttribute struct {
 	Name     string
 	Required bool
 	Default  map[string]interface{}
+	// If Sensitive is true, the values are not shown in the outputs.
+	Sensitive bool
 }
 
 func (attr *MapAttribute) GetName() string {
@@ -144,10 +265,28 @@ func (attr *MapAttribute) GetDefault() interface{} {
 	return attr.Default
 }
 
+func (attr *MapAttribute) IsSensitive() bool {
+	return attr.Sensitive
+}
+
 func (attr *MapAttribute) ToGoValue(v lua.LValue) interface{} {
 	return toGoValue(v)
 }
 
+func (attr *MapAttribute) Validate(lv lua.LValue) error {
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return nil
+	case *lua.LTable:
+		if v.MaxN() > 0 {
+			return fmt.Errorf("'%s' attribute must be a table that has string keys.", attr.Name)
+		}
+		return nil
+	default:
+		return typeError(attr.Name, "a table", lv)
+	}
+}
+
 // BoolAttribute
 type BoolAttribute struct {
 	Name     string
@@ -175,6 +314,15 @@ func (attr *BoolAttribute) ToGoValue(v lua.LValue) interface{} {
 	return toGoValue(v)
 }
 
+func (attr *BoolAttribute) Validate(lv lua.LValue) error {
+	switch lv.(type) {
+	case *lua.LNilType, lua.LBool:
+		return nil
+	default:
+		return typeError(attr.Name, "a boolean", lv)
+	}
+}
+
 type ComparableValue interface {
 	String() string
 	Nil() bool
@@ -198,6 +346,9 @@ type IntegerAttribute struct {
 	Name     string
 	Required bool
 	Default  *Integer
+	// Min and Max limit the range of the value if they are set.
+	Min *int
+	Max *int
 }
 
 func (attr *IntegerAttribute) GetName() string {
@@ -250,6 +401,34 @@ func (attr *IntegerAttribute) ToGoValue(lv lua.LValue) interface{} {
 	panic("'" + attr.Name + "' must be a number")
 }
 
+func (attr *IntegerAttribute) Validate(lv lua.LValue) error {
+	var n int
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LNumber:
+		n = int(float64(v))
+	case lua.LString:
+		i, err := strconv.Atoi(string(v))
+		if err != nil {
+			return fmt.Errorf("'%s' attribute must be an integer but got '%s'.", attr.Name, string(v))
+		}
+		n = i
+	default:
+		return typeError(attr.Name, "an integer", lv)
+	}
+
+	if attr.Min != nil && n < *attr.Min {
+		return fmt.Errorf("'%s' attribute must be greater than or equal to %d but got %d.", attr.Name, *attr.Min, n)
+	}
+
+	if attr.Max != nil && n > *attr.Max {
+		return fmt.Errorf("'%s' attribute must be less than or equal to %d but got %d.", attr.Name, *attr.Max, n)
+	}
+
+	return nil
+}
+
 // LFunctionAttribute
 type LFunctionAttribute struct {
 	Name     string
@@ -282,6 +461,59 @@ func (attr *LFunctionAttribute) ToGoValue(lv lua.LValue) interface{} {
 	return v
 }
 
+func (attr *LFunctionAttribute) Validate(lv lua.LValue) error {
+	switch lv.(type) {
+	case *lua.LNilType, *lua.LFunction:
+		return nil
+	default:
+		return typeError(attr.Name, "a function", lv)
+	}
+}
+
+// GuardAttribute is a command string or a lua function.
+type GuardAttribute struct {
+	Name     string
+	Required bool
+}
+
+func (attr *GuardAttribute) GetName() string {
+	return attr.Name
+}
+
+func (attr *GuardAttribute) IsRequired() bool {
+	return attr.Required
+}
+
+func (attr *GuardAttribute) HasDefault() bool {
+	return false
+}
+
+func (attr *GuardAttribute) GetDefault() interface{} {
+	return nil
+}
+
+func (attr *GuardAttribute) ToGoValue(lv lua.LValue) interface{} {
+	if fn, ok := lv.(*lua.LFunction); ok {
+		return fn
+	}
+
+	v := toGoValue(lv)
+	if fv, ok := v.(float64); ok {
+		v = fmt.Sprintf("%v", fv)
+	}
+
+	return v
+}
+
+func (attr *GuardAttribute) Validate(lv lua.LValue) error {
+	switch lv.(type) {
+	case *lua.LNilType, lua.LString, lua.LNumber, *lua.LFunction:
+		return nil
+	default:
+		return typeError(attr.Name, "a command string or a function", lv)
+	}
+}
+
 // NotifiesAttribute
 type NotifiesAttribute struct {
 	Name     string
@@ -310,17 +542,19 @@ func (attr *NotifiesAttribute) ToGoValue(lv lua.LValue) interface{} {
 	//   notifies = {"restart", "httpd"}
 	//   notifies = {"restart", "service[httpd]", "immediately"}
 	//   notifies = {{"restart", "service[httpd]", "immediately"}, {"restart", "service[nginx]"}}
+	// subscribes attribute has the same syntax. but the resource description is a source resource.
+	//   subscribes = {"restart", "template[/etc/nginx/nginx.conf]"}
 
 	notifications := []*Notification{}
 
 	v, ok := lv.(*lua.LTable)
 	if !ok {
-		panic("notifies must be array table")
+		panic(attr.Name + " must be array table")
 	}
 
 	maxn := v.MaxN()
 	if maxn == 0 { // table
-		panic("notifies must be array table")
+		panic(attr.Name + " must be array table")
 	} else { // array
 		if _, ok := v.RawGetInt(1).(lua.LString); ok {
 			// only one notificaton config
@@ -330,7 +564,7 @@ func (attr *NotifiesAttribute) ToGoValue(lv lua.LValue) interface{} {
 			for i := 1; i <= maxn; i++ {
 				vt, ok := v.RawGetInt(i).(*lua.LTable)
 				if !ok {
-					panic("notifies must be array table")
+					panic(attr.Name + " must be array table")
 				}
 				notifications = append(notifications, attr.createNotification(vt))
 			}
@@ -340,6 +574,26 @@ func (attr *NotifiesAttribute) ToGoValue(lv lua.LValue) interface{} {
 	return notifications
 }
 
+func (attr *NotifiesAttribute) Validate(lv lua.LValue) (err error) {
+	if lv == lua.LNil {
+		return nil
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("'%s' attribute is invalid: %v", attr.Name, e)
+		}
+	}()
+
+	for _, n := range attr.ToGoValue(lv).([]*Notification) {
+		if err := n.Validate(); err != nil {
+			return fmt.Errorf("'%s' attribute is invalid: %v", attr.Name, err)
+		}
+	}
+
+	return nil
+}
+
 func (attr *NotifiesAttribute) createNotification(config *lua.LTable) *Notification {
 	if lv := config.RawGetInt(1); lv == lua.LNil {
 		panic("invalid notification config")
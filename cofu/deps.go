@@ -0,0 +1,413 @@
+package cofu
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// DepsManifestFile is the manifest of the recipe dependencies. It is placed next to the main recipe.
+	DepsManifestFile = "cofu-deps.toml"
+	// DepsLockFile has the resolved revisions and the checksums of the dependencies.
+	DepsLockFile = "cofu-deps.lock"
+	// DepsVendorDir is the directory that the dependencies are installed into.
+	DepsVendorDir = "vendor"
+)
+
+var depNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+type DepsManifest struct {
+	Deps map[string]*Dep `toml:"deps"`
+}
+
+// Dep is a recipe dependency. The source is a go-getter style source.
+type Dep struct {
+	Source string `toml:"source"`
+	Ref    string `toml:"ref"`
+}
+
+type DepsLock struct {
+	Deps map[string]*LockedDep `toml:"deps"`
+}
+
+type LockedDep struct {
+	Source string `toml:"source"`
+	Ref    string `toml:"ref"`
+	// Revision is the commit of the git source. It is empty for the other sources.
+	Revision string `toml:"revision"`
+	Checksum string `toml:"checksum"`
+}
+
+// LoadDepsManifest loads the manifest in the dir. It returns nil if the manifest doesn't exist.
+func LoadDepsManifest(dir string) (*DepsManifest, error) {
+	manifestFile := filepath.Join(dir, DepsManifestFile)
+	if _, err := os.Stat(manifestFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	manifest := &DepsManifest{}
+	if _, err := toml.DecodeFile(manifestFile, manifest); err != nil {
+		return nil, fmt.Errorf("%s: %v", manifestFile, err)
+	}
+
+	for name, dep := range manifest.Deps {
+		if !depNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s: invalid dependency name '%s'", manifestFile, name)
+		}
+		if dep.Source == "" {
+			return nil, fmt.Errorf("%s: dependency '%s' must have 'source'", manifestFile, name)
+		}
+		if dep.Ref != "" && sourceVCS(dep.Source) == "" {
+			return nil, fmt.Errorf("%s: dependency '%s' has 'ref' but it is only supported for git and hg sources", manifestFile, name)
+		}
+	}
+
+	return manifest, nil
+}
+
+// LoadDepsLock loads the lockfile in the dir. It returns an empty lock if the lockfile doesn't exist.
+func LoadDepsLock(dir string) (*DepsLock, error) {
+	lock := &DepsLock{Deps: map[string]*LockedDep{}}
+
+	lockFile := filepath.Join(dir, DepsLockFile)
+	if _, err := os.Stat(lockFile); os.IsNotExist(err) {
+		return lock, nil
+	}
+
+	if _, err := toml.DecodeFile(lockFile, lock); err != nil {
+		return nil, fmt.Errorf("%s: %v", lockFile, err)
+	}
+	if lock.Deps == nil {
+		lock.Deps = map[string]*LockedDep{}
+	}
+
+	return lock, nil
+}
+
+// LoadDeps loads the manifest in the dir to resolve include_recipe "dep_name/recipe" against the vendor directory.
+// The vendor directories are verified by the checksums in the lockfile when they are included first.
+func (app *App) LoadDeps(dir string) error {
+	manifest, err := LoadDepsManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	// the resources run in their basepath. the paths must not be relative to the working directory.
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	app.depsDir = dir
+	app.deps = map[string]string{}
+	app.verifiedDeps = map[string]bool{}
+	if manifest == nil {
+		return nil
+	}
+
+	for name := range manifest.Deps {
+		app.deps[name] = filepath.Join(dir, DepsVendorDir, name)
+	}
+
+	return nil
+}
+
+// InstallDeps fetches the dependencies in the manifest of the dir into the vendor directory and writes the lockfile.
+// The dependencies that are locked with the same source and ref are fetched at the locked revision and verified by the checksums.
+func (app *App) InstallDeps(dir string) error {
+	manifest, err := LoadDepsManifest(dir)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return fmt.Errorf("%s is not found in '%s'", DepsManifestFile, dir)
+	}
+
+	lock, err := LoadDepsLock(dir)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range manifest.Deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	newLock := &DepsLock{Deps: map[string]*LockedDep{}}
+	for _, name := range names {
+		dep := manifest.Deps[name]
+		locked := lock.Deps[name]
+		if locked != nil && (locked.Source != dep.Source || locked.Ref != dep.Ref) {
+			// the manifest was changed. resolve it again.
+			locked = nil
+		}
+
+		ref := dep.Ref
+		if locked != nil && locked.Revision != "" {
+			ref = locked.Revision
+		}
+
+		app.Logger.Infof("Installing dependency '%s' from '%s'", name, app.MaskSecrets(dep.Source))
+
+		vendorDir := filepath.Join(dir, DepsVendorDir, name)
+		tmpdir := vendorDir + ".installing"
+		if err := os.RemoveAll(tmpdir); err != nil {
+			return err
+		}
+
+		if err := app.fetch(sourceWithRef(dep.Source, ref), tmpdir); err != nil {
+			os.RemoveAll(tmpdir)
+			return fmt.Errorf("failed to install dependency '%s': %v", name, err)
+		}
+
+		checksum, err := dirChecksum(tmpdir)
+		if err != nil {
+			os.RemoveAll(tmpdir)
+			return err
+		}
+
+		if locked != nil && locked.Checksum != checksum {
+			os.RemoveAll(tmpdir)
+			return fmt.Errorf("checksum mismatch of dependency '%s': locked %s but got %s", name, locked.Checksum, checksum)
+		}
+
+		if err := os.RemoveAll(vendorDir); err != nil {
+			return err
+		}
+		if err := os.Rename(tmpdir, vendorDir); err != nil {
+			return err
+		}
+
+		newLock.Deps[name] = &LockedDep{
+			Source:   dep.Source,
+			Ref:      dep.Ref,
+			Revision: gitRevision(vendorDir),
+			Checksum: checksum,
+		}
+	}
+
+	if err := app.pruneVendorDir(filepath.Join(dir, DepsVendorDir), manifest); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("# This file is generated by 'cofu -install-deps'. Do not edit it manually.\n\n")
+	if err := toml.NewEncoder(&b).Encode(newLock); err != nil {
+		return err
+	}
+
+	lockFile := filepath.Join(dir, DepsLockFile)
+	if err := ioutil.WriteFile(lockFile, b.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	app.Logger.Infof("Wrote %s", lockFile)
+
+	return nil
+}
+
+// pruneVendorDir removes the directories of the dependencies that are not in the manifest.
+func (app *App) pruneVendorDir(vendorDir string, manifest *DepsManifest) error {
+	entries, err := ioutil.ReadDir(vendorDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if _, ok := manifest.Deps[entry.Name()]; ok {
+			continue
+		}
+
+		app.Logger.Infof("Removing '%s' that is not in %s", filepath.Join(vendorDir, entry.Name()), DepsManifestFile)
+		if err := os.RemoveAll(filepath.Join(vendorDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveDepRecipe resolves the path like 'dep_name/recipe' to the recipe file in the vendor directory.
+func (app *App) resolveDepRecipe(path string) (string, bool, error) {
+	if app.Parent != nil {
+		return app.Parent.resolveDepRecipe(path)
+	}
+
+	parts := strings.SplitN(filepath.ToSlash(path), "/", 2)
+	vendorDir, ok := app.deps[parts[0]]
+	if !ok {
+		return "", false, nil
+	}
+
+	if _, err := os.Stat(vendorDir); os.IsNotExist(err) {
+		return "", true, fmt.Errorf("dependency '%s' is not installed. Run 'cofu -install-deps'.", parts[0])
+	}
+
+	if !app.verifiedDeps[parts[0]] {
+		if err := verifyDep(app.depsDir, parts[0], vendorDir); err != nil {
+			return "", true, err
+		}
+		app.verifiedDeps[parts[0]] = true
+	}
+
+	recipe := DefaultSourceRecipe
+	if len(parts) == 2 && parts[1] != "" {
+		recipe = parts[1]
+	}
+
+	resolved := filepath.Join(vendorDir, recipe)
+	if !strings.HasPrefix(resolved, vendorDir+string(filepath.Separator)) {
+		return "", true, fmt.Errorf("'%s' is out of dependency '%s'", recipe, parts[0])
+	}
+
+	return resolved, true, nil
+}
+
+// verifyDep checks the vendor directory of the dependency has the checksum in the lockfile of the dir.
+func verifyDep(dir, name, vendorDir string) error {
+	lock, err := LoadDepsLock(dir)
+	if err != nil {
+		return err
+	}
+
+	locked := lock.Deps[name]
+	if locked == nil {
+		return fmt.Errorf("dependency '%s' is not locked. Run 'cofu -install-deps'.", name)
+	}
+
+	checksum, err := dirChecksum(vendorDir)
+	if err != nil {
+		return err
+	}
+
+	if checksum != locked.Checksum {
+		return fmt.Errorf("checksum mismatch of dependency '%s': locked %s but '%s' has %s. Run 'cofu -install-deps'.", name, locked.Checksum, vendorDir, checksum)
+	}
+
+	return nil
+}
+
+// sourceWithRef adds the ref to the query string of the git or hg source.
+// The other sources don't support the ref, so they are returned as is.
+func sourceWithRef(source, ref string) string {
+	if ref == "" {
+		return source
+	}
+
+	var param string
+	switch sourceVCS(source) {
+	case "git":
+		param = "ref"
+	case "hg":
+		param = "rev"
+	default:
+		return source
+	}
+
+	if strings.Contains(source, "?") {
+		return source + "&" + param + "=" + ref
+	}
+
+	return source + "?" + param + "=" + ref
+}
+
+// sourceVCS returns "git" or "hg" if the source is fetched from the repository. Otherwise it returns an empty string.
+func sourceVCS(source string) string {
+	switch {
+	case strings.HasPrefix(source, "git::"):
+		return "git"
+	case strings.HasPrefix(source, "hg::"):
+		return "hg"
+	}
+
+	// go-getter detects the git repositories by the forms like 'github.com/org/repo' and 'git@github.com:org/repo.git'.
+	src, _ := splitSourceSubdir(source)
+	if i := strings.Index(src, "?"); i >= 0 {
+		src = src[:i]
+	}
+	if strings.HasPrefix(src, "github.com/") || strings.HasPrefix(src, "git@") || strings.HasSuffix(src, ".git") {
+		return "git"
+	}
+
+	return ""
+}
+
+// gitRevision returns the commit of the git working tree in the dir, or an empty string if it isn't a git repository.
+func gitRevision(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return ""
+	}
+
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// dirChecksum calculates the checksum of the files in the dir except the .git directory.
+func dirChecksum(dir string) (string, error) {
+	h := sha256.New()
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() && fi.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "link %s %s\n", rel, target)
+		case fi.Mode().IsRegular():
+			fmt.Fprintf(h, "file %s %d\n", rel, fi.Size())
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if _, err := io.Copy(h, f); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
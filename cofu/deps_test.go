@@ -0,0 +1,147 @@
+package cofu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppInstallDeps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_deps_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := `
+[deps.nginx]
+source = "git::file:///srv/git/cofu-nginx.git"
+ref = "v1.2.0"
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, DepsManifestFile), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content := `test "nginx" {}`
+	fetched := []string{}
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.Fetcher = func(src, dst string) error {
+		fetched = append(fetched, src)
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, "site.lua"), []byte(content), 0644)
+	}
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadDeps(rel); err != nil {
+		t.Fatal(err)
+	}
+	if app.deps["nginx"] != filepath.Join(dir, DepsVendorDir, "nginx") {
+		t.Errorf("the vendor directory must be an absolute path but got %s", app.deps["nginx"])
+	}
+	if err := app.LoadRecipe(`include_recipe "nginx/site"`); err == nil || !strings.Contains(err.Error(), "is not installed") {
+		t.Errorf("not installed dependency must be an error but got %v", err)
+	}
+
+	// the dependency that was removed from the manifest.
+	orphan := filepath.Join(dir, DepsVendorDir, "old")
+	if err := os.MkdirAll(orphan, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.InstallDeps(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("the orphaned vendor directory must be removed")
+	}
+
+	if len(fetched) != 1 || fetched[0] != "git::file:///srv/git/cofu-nginx.git?ref=v1.2.0" {
+		t.Errorf("unexpected fetched sources %v", fetched)
+	}
+
+	lock, err := LoadDepsLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := lock.Deps["nginx"]
+	if locked == nil || locked.Ref != "v1.2.0" || !strings.HasPrefix(locked.Checksum, "sha256:") {
+		t.Fatalf("unexpected lock %+v", locked)
+	}
+
+	if err := app.LoadRecipe(`include_recipe "nginx/site"`); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Resources) != 1 || app.Resources[0].Desc() != "test[nginx]" {
+		t.Errorf("unexpected resources %v", app.Resources)
+	}
+
+	// the content of the locked dependency must not be changed.
+	content = `test "changed" {}`
+	if err := app.InstallDeps(dir); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("changed dependency must be an error but got %v", err)
+	}
+
+	// the tampered vendor directory must not be loaded.
+	if err := ioutil.WriteFile(filepath.Join(dir, DepsVendorDir, "nginx", "site.lua"), []byte(`test "tampered" {}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.LoadDeps(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.LoadRecipe(`include_recipe "nginx/site"`); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("tampered dependency must be an error but got %v", err)
+	}
+}
+
+func TestLoadDepsManifestRef(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_deps_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := `
+[deps.php]
+source = "https://example.com/recipes/cofu-php.tar.gz"
+ref = "v1.0.0"
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, DepsManifestFile), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadDepsManifest(dir); err == nil || !strings.Contains(err.Error(), "only supported for git and hg sources") {
+		t.Errorf("ref of the archive source must be an error but got %v", err)
+	}
+
+	cases := map[string]string{
+		"git::https://example.com/repo.git":     "git::https://example.com/repo.git?ref=v1",
+		"github.com/example/repo//site?depth=1": "github.com/example/repo//site?depth=1&ref=v1",
+		"hg::https://example.com/repo":          "hg::https://example.com/repo?rev=v1",
+		"https://example.com/recipes.tar.gz":    "https://example.com/recipes.tar.gz",
+	}
+	for source, expected := range cases {
+		if actual := sourceWithRef(source, "v1"); actual != expected {
+			t.Errorf("%s: expected %s but got %s", source, expected, actual)
+		}
+	}
+}
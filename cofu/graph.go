@@ -0,0 +1,115 @@
+package cofu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ResourceGraph is a dependency graph of resources.
+// The edges are defined by 'depends_on' attribute of the resources.
+type ResourceGraph struct {
+	Resources []*Resource
+	// Dependencies maps a resource to the resources that it depends on.
+	Dependencies map[*Resource][]*Resource
+}
+
+func NewResourceGraph(resources []*Resource) (*ResourceGraph, error) {
+	g := &ResourceGraph{
+		Resources:    resources,
+		Dependencies: map[*Resource][]*Resource{},
+	}
+
+	descMap := map[string][]*Resource{}
+	for _, r := range resources {
+		descMap[r.Desc()] = append(descMap[r.Desc()], r)
+	}
+
+	for _, r := range resources {
+		for _, desc := range r.GetStringSliceAttribute("depends_on") {
+			deps, ok := descMap[desc]
+			if !ok {
+				return nil, r.validationErrorf("resource '%s': depends on '%s' but it is not found.", r.Desc(), desc)
+			}
+			for _, dep := range deps {
+				if !containsResource(g.Dependencies[r], dep) {
+					g.Dependencies[r] = append(g.Dependencies[r], dep)
+				}
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// Sort returns resources in topologically sorted order.
+// Resources that do not have dependencies keep the order in which they were registered.
+func (g *ResourceGraph) Sort() ([]*Resource, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	sorted := make([]*Resource, 0, len(g.Resources))
+	states := map[*Resource]int{}
+	path := []*Resource{}
+
+	var visit func(r *Resource) error
+	visit = func(r *Resource) error {
+		switch states[r] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("circular dependency detected: %s", cycleChain(path, r))
+		}
+
+		states[r] = visiting
+		path = append(path, r)
+
+		for _, dep := range g.Dependencies[r] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		states[r] = visited
+		sorted = append(sorted, r)
+
+		return nil
+	}
+
+	for _, r := range g.Resources {
+		if err := visit(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
+// cycleChain returns string like 'a[x] -> b[y] -> a[x]'
+func cycleChain(path []*Resource, r *Resource) string {
+	descs := []string{}
+	for i, p := range path {
+		if p == r {
+			for _, c := range path[i:] {
+				descs = append(descs, c.Desc())
+			}
+			break
+		}
+	}
+	descs = append(descs, r.Desc())
+
+	return strings.Join(descs, " -> ")
+}
+
+func containsResource(resources []*Resource, r *Resource) bool {
+	for _, rr := range resources {
+		if rr == r {
+			return true
+		}
+	}
+
+	return false
+}
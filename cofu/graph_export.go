@@ -0,0 +1,198 @@
+package cofu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GraphExport is a resource graph of the loaded recipes to visualize it.
+type GraphExport struct {
+	Resources []*GraphResource `json:"resources"`
+	Edges     []*GraphEdge     `json:"edges"`
+}
+
+type GraphResource struct {
+	ID       string `json:"id"`
+	Resource string `json:"resource"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Basepath string `json:"basepath"`
+	Recipe   string `json:"recipe"`
+	Line     int    `json:"line"`
+	// Order is the position in the order of converging.
+	Order         int                   `json:"order"`
+	DependsOn     []string              `json:"depends_on"`
+	Notifications []*NotificationReport `json:"notifications"`
+}
+
+// GraphEdge is an edge from a resource to another one.
+// The 'depends_on' edge is from a dependency to the resource that depends on it.
+// The 'notifies' edge is from a notifying resource to the target resource.
+type GraphEdge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Type   string `json:"type"`
+	Action string `json:"action,omitempty"`
+	Timing string `json:"timing,omitempty"`
+}
+
+// ExportGraph returns the graph of the loaded resources without converging them.
+// The edges are only the explicit dependencies and the notifications. The order of converging is the Order of the resources.
+func (app *App) ExportGraph() (*GraphExport, error) {
+	if errs := app.prepare(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	graph, err := NewResourceGraph(app.Resources)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted, err := graph.Sort()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[*Resource]string{}
+	for i, r := range app.Resources {
+		ids[r] = "r" + strconv.Itoa(i)
+	}
+
+	order := map[*Resource]int{}
+	for i, r := range sorted {
+		order[r] = i + 1
+	}
+
+	ge := &GraphExport{
+		Resources: []*GraphResource{},
+		Edges:     []*GraphEdge{},
+	}
+
+	for _, r := range app.Resources {
+		recipe, line := splitLocation(r.Location)
+		gr := &GraphResource{
+			ID:            ids[r],
+			Resource:      r.Desc(),
+			Type:          r.ResourceType.Name,
+			Name:          r.Name,
+			Basepath:      r.Basepath,
+			Recipe:        recipe,
+			Line:          line,
+			Order:         order[r],
+			DependsOn:     []string{},
+			Notifications: []*NotificationReport{},
+		}
+
+		for _, dep := range graph.Dependencies[r] {
+			gr.DependsOn = append(gr.DependsOn, dep.Desc())
+			ge.Edges = append(ge.Edges, &GraphEdge{
+				From: ids[dep],
+				To:   ids[r],
+				Type: "depends_on",
+			})
+		}
+
+		for _, n := range r.Notifications {
+			gr.Notifications = append(gr.Notifications, &NotificationReport{
+				Action: n.Action,
+				Target: n.TargetResourceDesc,
+				Timing: n.Timing,
+			})
+			ge.Edges = append(ge.Edges, &GraphEdge{
+				From:   ids[r],
+				To:     ids[app.FindOneResource(n.TargetResourceDesc)],
+				Type:   "notifies",
+				Action: n.Action,
+				Timing: n.Timing,
+			})
+		}
+
+		ge.Resources = append(ge.Resources, gr)
+	}
+
+	return ge, nil
+}
+
+func (ge *GraphExport) Write(w io.Writer, format string) error {
+	switch format {
+	case "dot":
+		return ge.WriteDOT(w)
+	case "json":
+		return ge.WriteJSON(w)
+	default:
+		return fmt.Errorf("unsupported graph format '%s'", format)
+	}
+}
+
+func (ge *GraphExport) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(ge, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// WriteDOT writes the graph in Graphviz DOT language.
+// The resources are grouped by the recipe files.
+func (ge *GraphExport) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("digraph cofu {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+
+	recipes := []string{}
+	resourcesByRecipe := map[string][]*GraphResource{}
+	for _, gr := range ge.Resources {
+		if _, ok := resourcesByRecipe[gr.Recipe]; !ok {
+			recipes = append(recipes, gr.Recipe)
+		}
+		resourcesByRecipe[gr.Recipe] = append(resourcesByRecipe[gr.Recipe], gr)
+	}
+	sort.Strings(recipes)
+
+	for i, recipe := range recipes {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%s;\n", strconv.Quote(recipe))
+		for _, gr := range resourcesByRecipe[recipe] {
+			fmt.Fprintf(&b, "    %s [label=%s];\n", gr.ID, strconv.Quote(fmt.Sprintf("%d. %s", gr.Order, gr.Resource)))
+		}
+		b.WriteString("  }\n")
+	}
+
+	for _, e := range ge.Edges {
+		switch e.Type {
+		case "notifies":
+			fmt.Fprintf(&b, "  %s -> %s [style=dashed, label=%s];\n", e.From, e.To, strconv.Quote(e.Action+" ("+e.Timing+")"))
+		default:
+			fmt.Fprintf(&b, "  %s -> %s;\n", e.From, e.To)
+		}
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// splitLocation splits the location like 'recipe.lua:3:' into the file and the line.
+func splitLocation(location string) (string, int) {
+	location = strings.TrimSuffix(location, ":")
+	i := strings.LastIndex(location, ":")
+	if i < 0 {
+		return location, 0
+	}
+
+	line, err := strconv.Atoi(location[i+1:])
+	if err != nil {
+		return location, 0
+	}
+
+	return location[:i], line
+}
@@ -0,0 +1,64 @@
+package cofu
+
+// EventType is a type of the events that are emitted while converging.
+type EventType string
+
+const (
+	// EventResourceStarted is emitted when a resource starts to be evaluated.
+	EventResourceStarted EventType = "resource_started"
+	// EventResourceSkipped is emitted when a resource is skipped by only_if or not_if. Reason has the guard name.
+	EventResourceSkipped EventType = "resource_skipped"
+	// EventDifference is emitted for each attribute that will change, after the final attempt of the action.
+	EventDifference EventType = "difference"
+	// EventActionFinished is emitted when an action of a resource finished. Error has the error of the action.
+	EventActionFinished EventType = "action_finished"
+	// EventNotificationQueued is emitted when a delayed notification is queued.
+	EventNotificationQueued EventType = "notification_queued"
+	// EventNotificationFired is emitted when a notification runs the target resource.
+	EventNotificationFired EventType = "notification_fired"
+	// EventRunFinished is emitted when App.Run finished. Error has the result of the Run.
+	EventRunFinished EventType = "run_finished"
+)
+
+// Event is passed to the hooks.
+// The fields that are not related to the type of the event are zero values.
+// Current and Desired are masked if the attribute is sensitive or has secrets.
+type Event struct {
+	Type         EventType
+	Resource     *Resource
+	Action       string
+	Reason       string
+	Attribute    string
+	Current      interface{}
+	Desired      interface{}
+	Notification *Notification
+	Error        error
+}
+
+// Hook is a function that receives the events of App.
+// Hooks are called one at a time even if resources are converged in parallel.
+type Hook func(e *Event)
+
+// AddHook registers the hook into the app.
+func (app *App) AddHook(hook Hook) {
+	app.Hooks = append(app.Hooks, hook)
+}
+
+func (app *App) emit(e *Event) {
+	if app.Parent != nil {
+		// the events of the child app are handled by the hooks of the root app.
+		app.Parent.emit(e)
+		return
+	}
+
+	if len(app.Hooks) == 0 {
+		return
+	}
+
+	app.hooksMutex.Lock()
+	defer app.hooksMutex.Unlock()
+
+	for _, hook := range app.Hooks {
+		hook(e)
+	}
+}
@@ -0,0 +1,100 @@
+package cofu
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestAppHooks(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := []string{}
+	app.AddHook(func(e *Event) {
+		desc := ""
+		if e.Resource != nil {
+			desc = e.Resource.Desc()
+		}
+		events = append(events, string(e.Type)+":"+desc)
+	})
+
+	if err := app.LoadRecipe(`
+test "a" {
+    notifies = {"run", "test[c]", "delayed"},
+}
+test "b" {
+    only_if = "false",
+}
+test "c" {
+    action = "nothing",
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"resource_started:test[a]",
+		"difference:test[a]",
+		"action_finished:test[a]",
+		"notification_queued:test[a]",
+		"resource_started:test[b]",
+		"resource_skipped:test[b]",
+		"resource_started:test[c]",
+		"difference:test[c]",
+		"action_finished:test[c]",
+		"notification_fired:test[c]",
+		"run_finished:",
+	}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected events\n%s", strings.Join(events, "\n"))
+	}
+}
+
+func TestAppRunContextCancel(t *testing.T) {
+	for _, parallel := range []int{1, 2} {
+		executed := []string{}
+
+		app := NewApp()
+		app.Parallel = parallel
+		app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+		if err := app.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		app.AddHook(func(e *Event) {
+			if e.Type == EventActionFinished {
+				cancel()
+			}
+		})
+
+		if err := app.LoadRecipe(`
+test "a" {}
+test "b" {
+    depends_on = "test[a]",
+}
+`); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := app.RunContext(ctx, false); err != context.Canceled {
+			t.Errorf("parallel %d: expected context.Canceled but got %v", parallel, err)
+		}
+
+		if strings.Join(executed, ",") != "test[a]" {
+			t.Errorf("parallel %d: unexpected executed resources %v", parallel, executed)
+		}
+		app.Close()
+	}
+}
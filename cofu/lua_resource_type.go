@@ -0,0 +1,235 @@
+package cofu
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// fnResourceType declares a resource type in a recipe.
+func fnResourceType(L *lua.LState) int {
+	name := L.CheckString(1)
+
+	// procedural style
+	if L.GetTop() == 2 {
+		tb := L.CheckTable(2)
+		registerLuaResourceType(L, name, tb)
+		return 0
+	}
+
+	// DSL style
+	L.Push(L.NewFunction(func(L *lua.LState) int {
+		tb := L.CheckTable(1)
+		registerLuaResourceType(L, name, tb)
+		return 0
+	}))
+
+	return 1
+}
+
+func registerLuaResourceType(L *lua.LState, name string, config *lua.LTable) {
+	app, err := GetApp(L)
+	if err != nil {
+		L.RaiseError(err.Error())
+	}
+
+	resourceType, err := newLuaResourceType(name, config)
+	if err != nil {
+		L.RaiseError("resource_type '%s': %s", name, err.Error())
+	}
+
+	if err := app.loadResourceType(resourceType); err != nil {
+		L.RaiseError(err.Error())
+	}
+	app.ResourceTypes = append(app.ResourceTypes, resourceType)
+
+	L.SetGlobal(name, L.NewFunction(resourceType.LGFunction()))
+}
+
+func newLuaResourceType(name string, config *lua.LTable) (*ResourceType, error) {
+	resourceType := &ResourceType{
+		Name:       name,
+		Attributes: []Attribute{},
+		Actions:    map[string]ResourceAction{},
+		Composite:  true,
+
+		declaredInLua: true,
+	}
+
+	actions, ok := config.RawGetString("actions").(*lua.LTable)
+	if !ok {
+		return nil, errors.New("'actions' must be a table of functions.")
+	}
+
+	actionNames := []string{}
+	var err error
+	actions.ForEach(func(k, v lua.LValue) {
+		fn, ok := v.(*lua.LFunction)
+		if !ok {
+			err = fmt.Errorf("action '%s' must be a function.", k.String())
+			return
+		}
+		resourceType.Actions[k.String()] = luaResourceAction(fn)
+		actionNames = append(actionNames, k.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(actionNames) == 0 {
+		return nil, errors.New("it must have at least one action.")
+	}
+
+	var defaultAction string
+	if lv, ok := config.RawGetString("default_action").(lua.LString); ok {
+		defaultAction = string(lv)
+		if _, ok := resourceType.Actions[defaultAction]; !ok {
+			return nil, fmt.Errorf("default_action '%s' is not defined in the actions.", defaultAction)
+		}
+	} else if len(actionNames) == 1 {
+		defaultAction = actionNames[0]
+	} else {
+		return nil, errors.New("'default_action' is required if it has several actions.")
+	}
+
+	resourceType.Attributes = append(resourceType.Attributes, &StringSliceAttribute{
+		Name:     "action",
+		Default:  []string{defaultAction},
+		Required: true,
+	})
+
+	switch attributes := config.RawGetString("attributes").(type) {
+	case *lua.LNilType:
+	case *lua.LTable:
+		names := []string{}
+		specs := map[string]lua.LValue{}
+		attributes.ForEach(func(k, v lua.LValue) {
+			names = append(names, k.String())
+			specs[k.String()] = v
+		})
+		// keep the order of the attributes stable.
+		sort.Strings(names)
+
+		for _, attrName := range names {
+			if attrName == "action" {
+				return nil, errors.New("'action' attribute is reserved.")
+			}
+
+			attribute, err := newAttributeFromLua(attrName, specs[attrName])
+			if err != nil {
+				return nil, err
+			}
+			resourceType.Attributes = append(resourceType.Attributes, attribute)
+		}
+	default:
+		return nil, errors.New("'attributes' must be a table.")
+	}
+
+	return resourceType, nil
+}
+
+// newAttributeFromLua creates an attribute from a type name like "string" or a table like { type = "string", required = true }.
+func newAttributeFromLua(name string, spec lua.LValue) (Attribute, error) {
+	var typeName string
+	options := &lua.LTable{}
+
+	switch v := spec.(type) {
+	case lua.LString:
+		typeName = string(v)
+	case *lua.LTable:
+		options = v
+		typeName = lua.LVAsString(v.RawGetString("type"))
+	default:
+		return nil, fmt.Errorf("attribute '%s' must be a type name or a table.", name)
+	}
+
+	required := lua.LVAsBool(options.RawGetString("required"))
+	sensitive := lua.LVAsBool(options.RawGetString("sensitive"))
+	def := options.RawGetString("default")
+
+	switch typeName {
+	case "string":
+		attribute := &StringAttribute{
+			Name:        name,
+			Required:    required,
+			DefaultName: lua.LVAsBool(options.RawGetString("default_name")),
+			Sensitive:   sensitive,
+		}
+		if def != lua.LNil {
+			attribute.Default = lua.LVAsString(def)
+		}
+		return attribute, nil
+	case "bool", "boolean":
+		return &BoolAttribute{
+			Name:     name,
+			Required: required,
+			Default:  lua.LVAsBool(def),
+		}, nil
+	case "integer", "number":
+		attribute := &IntegerAttribute{
+			Name:     name,
+			Required: required,
+		}
+		if def != lua.LNil {
+			n, ok := def.(lua.LNumber)
+			if !ok {
+				return nil, fmt.Errorf("attribute '%s' must have a number as the default.", name)
+			}
+			attribute.Default = &Integer{V: int(n)}
+		}
+		return attribute, nil
+	case "map", "table":
+		attribute := &MapAttribute{
+			Name:      name,
+			Required:  required,
+			Sensitive: sensitive,
+		}
+		if def != lua.LNil {
+			m, ok := toGoValue(def).(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("attribute '%s' must have a table as the default.", name)
+			}
+			attribute.Default = m
+		}
+		return attribute, nil
+	default:
+		return nil, fmt.Errorf("attribute '%s' has unsupported type '%s'.", name, typeName)
+	}
+}
+
+// luaResourceAction runs the function in a child app.
+// The resource is updated if any resources defined in the function are updated.
+func luaResourceAction(fn *lua.LFunction) ResourceAction {
+	return func(r *Resource) error {
+		child := r.App.newChildApp(r)
+		defer child.Close()
+
+		// only the function runs on the goroutine of the LState.
+		// the resources defined in it are converged on the current goroutine.
+		if err := r.CallLState(func(L *lua.LState) error {
+			return child.callWithApp(fn, newLResource(L, r))
+		}); err != nil {
+			return err
+		}
+
+		err := child.Run(r.App.DryRun)
+		r.App.Report.merge(child.Report)
+		if err != nil {
+			return err
+		}
+
+		for _, rr := range child.Report.Resources {
+			if rr.Drifted {
+				r.drifted = true
+				r.report.drift()
+			}
+			if rr.Updated {
+				r.Update()
+			}
+		}
+
+		return nil
+	}
+}
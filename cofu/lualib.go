@@ -1,8 +1,10 @@
 package cofu
 
 import (
+	"fmt"
 	"github.com/cjoudrey/gluahttp"
 	"github.com/kohkimakimoto/cofu/infra/backend"
+	"github.com/kohkimakimoto/cofu/infra/util"
 	"github.com/kohkimakimoto/gluaenv"
 	"github.com/kohkimakimoto/gluafs"
 	"github.com/kohkimakimoto/gluaquestion"
@@ -10,11 +12,14 @@ import (
 	"github.com/kohkimakimoto/gluayaml"
 	"github.com/otm/gluash"
 	gluacrypto "github.com/tengattack/gluacrypto/crypto"
+	"github.com/yookoala/realpath"
 	"github.com/yuin/gluare"
 	"github.com/yuin/gopher-lua"
 	gluajson "layeh.com/gopher-json"
 	"net/http"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -33,6 +38,8 @@ func openLibs(app *App) {
 	L.SetGlobal("run_command", L.NewFunction(fnRunCommand))
 	L.SetGlobal("include_recipe", L.NewFunction(fnIncludeRecipe(app)))
 	L.SetGlobal("define", L.NewFunction(fnDefine))
+	L.SetGlobal("resource_type", L.NewFunction(fnResourceType))
+	L.SetGlobal("shell_escape", L.NewFunction(fnShellEscape))
 
 	// built-in packages
 	L.PreloadModule("json", gluajson.Loader)
@@ -58,6 +65,8 @@ func cofuLuaModuleLoader(app *App) func(*lua.LState) int {
 			"run_command":    fnRunCommand,
 			"include_recipe": fnIncludeRecipe(app),
 			"define":         fnDefine,
+			"resource_type":  fnResourceType,
+			"shell_escape":   fnShellEscape,
 		})
 
 		mt := L.NewTable()
@@ -182,33 +191,179 @@ func fnRunCommand(L *lua.LState) int {
 	}
 
 	i := app.Infra
-	result := i.RunCommand(command)
+	result := i.RunCommandWithOption(command, &backend.CommandOption{
+		Timeout: app.CommandTimeout,
+	})
+	if result.TimedOut {
+		L.RaiseError("command '%s' timed out after %v and was killed", app.MaskSecrets(command), app.CommandTimeout)
+	}
 
 	L.Push(newLCommandResult(L, result))
 	return 1
 }
 
+// fnShellEscape quotes the string to use it as a word in shell commands.
+func fnShellEscape(L *lua.LState) int {
+	L.Push(lua.LString(util.ShellEscape(L.CheckString(1))))
+	return 1
+}
+
 func fnIncludeRecipe(app *App) lua.LGFunction {
 	return func(L *lua.LState) int {
 		path := L.CheckString(1)
 
-		if !filepath.IsAbs(path) {
-			current := CurrentDir(L)
-			path = filepath.Join(current, path)
+		// a recipe is loaded only once by default. {once = false} loads it again.
+		once := true
+		if opts, ok := L.Get(2).(*lua.LTable); ok {
+			if v := opts.RawGetString("once"); v != lua.LNil {
+				once = lua.LVAsBool(v)
+			}
+		}
+
+		// the loaded recipes are tracked by the app that the resources are registered into.
+		current, err := GetApp(L)
+		if err != nil {
+			L.RaiseError(err.Error())
+		}
+
+		if strings.HasPrefix(path, BuiltinRecipePrefix) {
+			name := strings.TrimPrefix(path, BuiltinRecipePrefix)
+			if _, ok := app.BuiltinRecipes[name]; !ok {
+				err := fmt.Errorf("include_recipe: built-in recipe '%s' is not found.", name)
+				if app.Validating {
+					app.addValidationError(L.Where(1), err)
+					return 0
+				}
+				L.RaiseError(err.Error())
+			}
+
+			if once && current.loadedRecipes[path] {
+				app.Logger.Debugf("Skipped '%s' because it is already loaded.", path)
+				return 0
+			}
+			current.loadedRecipes[path] = true
+
+			if err := app.LoadBuiltinRecipe(name); err != nil {
+				panic(err)
+			}
+			return 0
+		}
+
+		if !IsRemoteSource(path) && hasGlobMeta(path) {
+			pattern := path
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(CurrentDir(L), pattern)
+			}
+
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				L.RaiseError("include_recipe: %v", err)
+			}
+			sort.Strings(matches)
+
+			if len(matches) == 0 {
+				app.Logger.Warnf("include_recipe: no recipes match '%s'.", pattern)
+			}
+
+			for _, match := range matches {
+				includeRecipeFile(L, app, current, match, once)
+			}
+			return 0
 		}
 
+		resolved, err := resolveIncludedRecipe(app, path, CurrentDir(L))
+		if err == ErrSourceNotFetched {
+			app.Logger.Warnf("include_recipe: '%s' is not validated because %v.", app.MaskSecrets(path), err)
+			return 0
+		}
+		if err != nil {
+			if app.Validating {
+				app.addValidationError(L.Where(1), fmt.Errorf("include_recipe: %v", err))
+				return 0
+			}
+			L.RaiseError("include_recipe: %v", err)
+		}
+		path = resolved
+
 		if !strings.HasSuffix(path, ".lua") {
 			path += ".lua"
 		}
 
-		if err := loadRecipeFile(path, app.LState, app); err != nil {
-			panic(err)
-		}
+		includeRecipeFile(L, app, current, path, once)
 
 		return 0
 	}
 }
 
+// includeRecipeFile loads the recipe file unless it is already loaded by the current app.
+func includeRecipeFile(L *lua.LState, app *App, current *App, path string, once bool) {
+	if app.Validating {
+		if _, err := os.Stat(path); err != nil {
+			app.addValidationError(L.Where(1), fmt.Errorf("include_recipe: '%s' is not found.", path))
+			return
+		}
+	}
+
+	key := recipeKey(path)
+	if once && current.loadedRecipes[key] {
+		app.Logger.Debugf("Skipped '%s' because it is already loaded.", path)
+		return
+	}
+	current.loadedRecipes[key] = true
+
+	if err := loadRecipeFile(path, app.LState, app); err != nil {
+		panic(err)
+	}
+}
+
+// recipeKey returns the real path of the recipe file to identify it.
+func recipeKey(path string) string {
+	if p, err := realpath.Realpath(path); err == nil {
+		return p
+	}
+
+	if p, err := filepath.Abs(path); err == nil {
+		return p
+	}
+
+	return path
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// resolveIncludedRecipe resolves the path of include_recipe.
+// It may be a remote source, a dependency in the vendor directory or a relative path from the current directory.
+func resolveIncludedRecipe(app *App, path, current string) (string, error) {
+	if IsRemoteSource(path) {
+		return app.ResolveRecipeSource(path, current)
+	}
+
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+
+	local := filepath.Join(current, path)
+	if !strings.HasSuffix(local, ".lua") {
+		local += ".lua"
+	}
+	if _, err := os.Stat(local); err == nil {
+		return local, nil
+	}
+
+	// the local recipe takes precedence over the dependency.
+	dep, ok, err := app.resolveDepRecipe(path)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return dep, nil
+	}
+
+	return local, nil
+}
+
 func fnDefine(L *lua.LState) int {
 	name := L.CheckString(1)
 
@@ -33,11 +33,56 @@ func (n *Notification) Validate() error {
 	return fmt.Errorf("'%s' is not valid notification timing. (Valid option is delayed or immediately)", n.Timing)
 }
 
+// ValidateTarget checks the target resource exists and supports the action.
+func (n *Notification) ValidateTarget() error {
+	r := n.DefinedInResource
+	targetResource := r.App.FindOneResource(n.TargetResourceDesc)
+	if targetResource == nil {
+		return fmt.Errorf("resource '%s': notification target '%s' is not found.", r.Desc(), n.TargetResourceDesc)
+	}
+
+	if targetResource.ResourceType.unresolved {
+		return nil
+	}
+
+	if _, ok := targetResource.ResourceType.Actions[n.Action]; !ok {
+		return fmt.Errorf("resource '%s': '%s' is not supported action of the notification target '%s'.", r.Desc(), n.Action, n.TargetResourceDesc)
+	}
+
+	return nil
+}
+
 func (n *Notification) Run() error {
 	targetResource := n.DefinedInResource.App.FindOneResource(n.TargetResourceDesc)
 	if targetResource == nil {
 		return fmt.Errorf("Not found target resource '%s'", n.TargetResourceDesc)
 	}
 
-	return targetResource.Run(n.Action)
+	if targetResource.IsExcluded() {
+		n.DefinedInResource.Logger().Warnf("%s: Notification '%s' to %s is skipped because the target resource is excluded by tags.", n.DefinedInResource.Desc(), n.Action, n.TargetResourceDesc)
+		return nil
+	}
+
+	if targetResource.App.isParallel() && targetResource != n.DefinedInResource {
+		// the target may be converged by another goroutine at the same time.
+		if !targetResource.tryLockRun() {
+			n.DefinedInResource.Logger().Warnf("%s: Notification '%s' to %s is delayed because the target resource is running.", n.DefinedInResource.Desc(), n.Action, n.TargetResourceDesc)
+			targetResource.App.EnqueueDelayedNotification(n)
+			return nil
+		}
+		defer targetResource.unlockRun()
+	}
+
+	// write log of the target resource to the same buffer in parallel mode.
+	if logger := n.DefinedInResource.logger; logger != nil {
+		targetResource.logger = logger
+		defer func() {
+			targetResource.logger = nil
+		}()
+	}
+
+	err := targetResource.Run(n.Action)
+	targetResource.App.emit(&Event{Type: EventNotificationFired, Resource: targetResource, Action: n.Action, Notification: n, Error: err})
+
+	return err
 }
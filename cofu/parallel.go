@@ -0,0 +1,139 @@
+package cofu
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	fatihColor "github.com/fatih/color"
+	"github.com/labstack/gommon/log"
+)
+
+// runResourcesInParallel converges resources that do not depend on each other concurrently.
+// The resources must be sorted by the graph.
+// If the context is canceled, it waits for the running resources and does not start new ones.
+func (app *App) runResourcesInParallel(ctx context.Context, resources []*Resource, graph *ResourceGraph) error {
+	type result struct {
+		r   *Resource
+		err error
+	}
+
+	// detect os before starting goroutines.
+	app.Infra.Command()
+
+	waiting := map[*Resource]int{}
+	for _, r := range resources {
+		waiting[r] = 0
+	}
+
+	dependents := map[*Resource][]*Resource{}
+	ready := []*Resource{}
+	for _, r := range resources {
+		for _, dep := range graph.Dependencies[r] {
+			// ignore the resources that are not converged (e.g. excluded by tags).
+			if _, ok := waiting[dep]; !ok {
+				continue
+			}
+			waiting[r]++
+			dependents[dep] = append(dependents[dep], r)
+		}
+
+		if waiting[r] == 0 {
+			ready = append(ready, r)
+		}
+	}
+
+	app.luaCalls = make(chan func())
+	defer func() {
+		app.luaCalls = nil
+	}()
+
+	results := make(chan *result)
+	running := 0
+	var firstErr error
+	done := ctx.Done()
+
+	for {
+		if firstErr == nil && ctx.Err() != nil {
+			firstErr = ctx.Err()
+		}
+
+		// stop starting new resources after an error occurred.
+		for firstErr == nil && running < app.Parallel && len(ready) > 0 {
+			r := ready[0]
+			ready = ready[1:]
+			running++
+
+			go func(r *Resource) {
+				results <- &result{r: r, err: app.runResourceWithBufferedLog(r)}
+			}(r)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		select {
+		case <-done:
+			// wake up to stop starting new resources.
+			done = nil
+		case fn := <-app.luaCalls:
+			fn()
+		case ret := <-results:
+			running--
+			if ret.err != nil {
+				if firstErr == nil {
+					firstErr = ret.err
+				}
+				continue
+			}
+
+			for _, dependent := range dependents[ret.r] {
+				waiting[dependent]--
+				if waiting[dependent] == 0 {
+					ready = append(ready, dependent)
+				}
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// runResourceWithBufferedLog runs the resource and writes its log at once after it finished.
+func (app *App) runResourceWithBufferedLog(r *Resource) (err error) {
+	// wait for the immediate notification that runs the resource on another goroutine.
+	r.lockRun()
+	defer r.unlockRun()
+
+	var buf bytes.Buffer
+	r.logger = newBufferedLogger(app, &buf)
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+
+		r.logger = nil
+
+		app.mutex.Lock()
+		defer app.mutex.Unlock()
+		app.Logger.Output().Write(buf.Bytes())
+	}()
+
+	return r.Run("")
+}
+
+func newBufferedLogger(app *App, w io.Writer) Logger {
+	logger := log.New("cofu")
+	logger.SetOutput(w)
+	logger.SetLevel(app.Logger.Level())
+	logger.SetPrefix(app.Logger.Prefix())
+	logger.SetHeader(app.LogHeader)
+	if !fatihColor.NoColor {
+		logger.EnableColor()
+	}
+
+	return logger
+}
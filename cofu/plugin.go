@@ -0,0 +1,416 @@
+package cofu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PluginPrefix is a prefix of the executable file names of the resource type plugins.
+const PluginPrefix = "cofu-resource-"
+
+// Plugin is an external executable that implements a resource type.
+// cofu sends a JSON request to the stdin of the plugin process and reads a JSON response from the stdout.
+type Plugin struct {
+	Path string
+	Spec *PluginSpec
+}
+
+// PluginSpec is a response of the 'describe' method.
+type PluginSpec struct {
+	Name          string                 `json:"name"`
+	Attributes    []*PluginAttributeSpec `json:"attributes"`
+	Actions       []string               `json:"actions"`
+	DefaultAction string                 `json:"default_action"`
+}
+
+type PluginAttributeSpec struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Required    bool        `json:"required"`
+	Default     interface{} `json:"default"`
+	DefaultName bool        `json:"default_name"`
+	Sensitive   bool        `json:"sensitive"`
+}
+
+type pluginRequest struct {
+	Method            string                 `json:"method"`
+	Action            string                 `json:"action,omitempty"`
+	Name              string                 `json:"name,omitempty"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
+	CurrentAttributes map[string]interface{} `json:"current_attributes,omitempty"`
+	DryRun            bool                   `json:"dry_run"`
+}
+
+type pluginResponse struct {
+	Attributes        map[string]interface{} `json:"attributes"`
+	CurrentAttributes map[string]interface{} `json:"current_attributes"`
+	Error             string                 `json:"error"`
+}
+
+// DiscoverPlugins finds the plugins in the directories.
+// If the plugins that have the same name exist, the first one is used.
+func DiscoverPlugins(dirs []string) ([]*Plugin, error) {
+	files, err := findPluginFiles(dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	plugins := []*Plugin{}
+	names := map[string]bool{}
+
+	for _, file := range files {
+		p, err := LoadPlugin(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if names[p.Spec.Name] {
+			continue
+		}
+		names[p.Spec.Name] = true
+
+		plugins = append(plugins, p)
+	}
+
+	return plugins, nil
+}
+
+// findPluginFiles returns the executable files that have PluginPrefix in the directories.
+func findPluginFiles(dirs []string) ([]string, error) {
+	files := []string{}
+
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, f := range infos {
+			if f.IsDir() || !strings.HasPrefix(f.Name(), PluginPrefix) || f.Mode()&0111 == 0 {
+				continue
+			}
+
+			files = append(files, filepath.Join(dir, f.Name()))
+		}
+	}
+
+	return files, nil
+}
+
+// unresolvedPluginResourceTypes returns the resource types of the plugins without running them.
+// They are used in validating mode. They accept any attributes and actions because the specs are unknown.
+func unresolvedPluginResourceTypes(dirs []string) ([]*ResourceType, error) {
+	files, err := findPluginFiles(dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	resourceTypes := []*ResourceType{}
+	names := map[string]bool{}
+
+	for _, file := range files {
+		name := strings.TrimPrefix(filepath.Base(file), PluginPrefix)
+		if names[name] {
+			continue
+		}
+		names[name] = true
+
+		resourceTypes = append(resourceTypes, &ResourceType{
+			Name:                  name,
+			UseFallbackAttributes: true,
+			unresolved:            true,
+		})
+	}
+
+	return resourceTypes, nil
+}
+
+// LoadPlugin runs the plugin with 'describe' method to get the spec.
+func LoadPlugin(path string) (*Plugin, error) {
+	p := &Plugin{
+		Path: path,
+	}
+
+	out, err := p.call(&pluginRequest{Method: "describe"}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &PluginSpec{}
+	if err := json.Unmarshal(out, spec); err != nil {
+		return nil, fmt.Errorf("plugin '%s': invalid response of 'describe': %v", path, err)
+	}
+
+	if spec.Name == "" {
+		spec.Name = strings.TrimPrefix(filepath.Base(path), PluginPrefix)
+	}
+
+	if len(spec.Actions) == 0 {
+		return nil, fmt.Errorf("plugin '%s': it must have at least one action.", path)
+	}
+
+	if spec.DefaultAction == "" {
+		spec.DefaultAction = spec.Actions[0]
+	}
+
+	p.Spec = spec
+
+	return p, nil
+}
+
+// ResourceType adapts the plugin into a resource type.
+// PreAction, SetCurrentAttributesFunc and the actions are delegated to the plugin process.
+func (p *Plugin) ResourceType() (*ResourceType, error) {
+	attributes := []Attribute{
+		&StringSliceAttribute{
+			Name:     "action",
+			Default:  []string{p.Spec.DefaultAction},
+			Required: true,
+		},
+	}
+
+	for _, spec := range p.Spec.Attributes {
+		attribute, err := spec.attribute()
+		if err != nil {
+			return nil, fmt.Errorf("plugin '%s': %v", p.Path, err)
+		}
+		attributes = append(attributes, attribute)
+	}
+
+	resourceType := &ResourceType{
+		Name:       p.Spec.Name,
+		Attributes: attributes,
+		Actions:    map[string]ResourceAction{},
+	}
+
+	resourceType.PreAction = func(r *Resource) error {
+		res, err := p.callResource(r, "pre_action", "")
+		if err != nil {
+			return err
+		}
+
+		for k, v := range res.Attributes {
+			attribute := resourceType.findAttribute(k)
+			if attribute == nil {
+				return fmt.Errorf("%s: plugin returned undefined attribute '%s'.", r.Desc(), k)
+			}
+			r.Attributes[k] = fromPluginValue(attribute, v)
+		}
+
+		return nil
+	}
+
+	resourceType.SetCurrentAttributesFunc = func(r *Resource) error {
+		res, err := p.callResource(r, "current_attributes", "")
+		if err != nil {
+			return err
+		}
+
+		for k, v := range res.CurrentAttributes {
+			switch v.(type) {
+			case []interface{}, map[string]interface{}:
+				return fmt.Errorf("%s: current attribute '%s' must be a scalar value.", r.Desc(), k)
+			}
+
+			if attribute := resourceType.findAttribute(k); attribute != nil {
+				v = fromPluginValue(attribute, v)
+			}
+			r.CurrentAttributes[k] = v
+		}
+
+		return nil
+	}
+
+	for _, action := range p.Spec.Actions {
+		action := action
+		resourceType.Actions[action] = func(r *Resource) error {
+			_, err := p.callResource(r, "action", action)
+			return err
+		}
+	}
+
+	return resourceType, nil
+}
+
+func (p *Plugin) callResource(r *Resource, method string, action string) (*pluginResponse, error) {
+	req := &pluginRequest{
+		Method:            method,
+		Action:            action,
+		Name:              r.Name,
+		Attributes:        map[string]interface{}{},
+		CurrentAttributes: map[string]interface{}{},
+		DryRun:            r.App.DryRun,
+	}
+
+	// send only the attributes that are defined by the plugin.
+	for _, spec := range p.Spec.Attributes {
+		if v, ok := r.Attributes[spec.Name]; ok {
+			req.Attributes[spec.Name] = toPluginValue(v)
+		}
+	}
+
+	for k, v := range r.CurrentAttributes {
+		req.CurrentAttributes[k] = toPluginValue(v)
+	}
+
+	r.Logger().Debugf("Calling plugin '%s' method: '%s'", p.Path, method)
+
+	out, err := p.call(req, r.commandTimeout())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", r.Desc(), err)
+	}
+
+	res := &pluginResponse{}
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, res); err != nil {
+			return nil, fmt.Errorf("%s: plugin '%s': invalid response of '%s': %v", r.Desc(), p.Path, method, err)
+		}
+	}
+
+	if res.Error != "" {
+		return nil, fmt.Errorf("%s: %s", r.Desc(), res.Error)
+	}
+
+	return res, nil
+}
+
+// call runs the plugin process and returns the stdout.
+func (p *Plugin) call(req *pluginRequest, timeout time.Duration) ([]byte, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("plugin '%s' timed out after %v and was killed", p.Path, timeout)
+		}
+		return nil, fmt.Errorf("plugin '%s' failed '%s': %v: %s", p.Path, req.Method, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func (spec *PluginAttributeSpec) attribute() (Attribute, error) {
+	switch spec.Type {
+	case "string":
+		attribute := &StringAttribute{
+			Name:        spec.Name,
+			Required:    spec.Required,
+			DefaultName: spec.DefaultName,
+			Sensitive:   spec.Sensitive,
+		}
+		if spec.Default != nil {
+			attribute.Default = fmt.Sprint(spec.Default)
+		}
+		return attribute, nil
+	case "string_slice":
+		attribute := &StringSliceAttribute{
+			Name:        spec.Name,
+			Required:    spec.Required,
+			DefaultName: spec.DefaultName,
+		}
+		if spec.Default != nil {
+			attribute.Default = toStringSlice(spec.Default)
+		}
+		return attribute, nil
+	case "bool":
+		b, _ := spec.Default.(bool)
+		return &BoolAttribute{
+			Name:     spec.Name,
+			Required: spec.Required,
+			Default:  b,
+		}, nil
+	case "integer":
+		attribute := &IntegerAttribute{
+			Name:     spec.Name,
+			Required: spec.Required,
+		}
+		if f, ok := spec.Default.(float64); ok {
+			attribute.Default = &Integer{V: int(f)}
+		}
+		return attribute, nil
+	case "map":
+		m, _ := spec.Default.(map[string]interface{})
+		return &MapAttribute{
+			Name:      spec.Name,
+			Required:  spec.Required,
+			Default:   m,
+			Sensitive: spec.Sensitive,
+		}, nil
+	default:
+		return nil, fmt.Errorf("attribute '%s' has unsupported type '%s'.", spec.Name, spec.Type)
+	}
+}
+
+// toPluginValue converts the attribute value to the value that can be encoded to JSON.
+func toPluginValue(v interface{}) interface{} {
+	switch converted := v.(type) {
+	case *Integer:
+		if converted.Nil() {
+			return nil
+		}
+		return converted.V
+	default:
+		return v
+	}
+}
+
+// fromPluginValue converts the value decoded from JSON to the attribute value.
+func fromPluginValue(attribute Attribute, v interface{}) interface{} {
+	switch attribute.(type) {
+	case *IntegerAttribute:
+		if f, ok := v.(float64); ok {
+			return &Integer{V: int(f)}
+		}
+		return &Integer{IsNil: true}
+	case *StringAttribute:
+		if v == nil {
+			return nil
+		}
+		return fmt.Sprint(v)
+	case *StringSliceAttribute:
+		return toStringSlice(v)
+	default:
+		return v
+	}
+}
+
+func toStringSlice(v interface{}) []string {
+	switch converted := v.(type) {
+	case []interface{}:
+		ret := []string{}
+		for _, s := range converted {
+			ret = append(ret, fmt.Sprint(s))
+		}
+		return ret
+	case string:
+		return []string{converted}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,70 @@
+package cofu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPluginScript = `#!/bin/sh
+input=$(cat)
+case "$input" in
+  *'"method":"describe"'*)
+    echo '{"name": "kv", "attributes": [{"name": "key", "type": "string", "default_name": true}, {"name": "value", "type": "string", "required": true}], "actions": ["set"]}'
+    ;;
+  *'"method":"current_attributes"'*)
+    echo '{"current_attributes": {"value": "old"}}'
+    ;;
+  *'"method":"action"'*)
+    echo "$input" > "$(dirname "$0")/action.json"
+    ;;
+esac
+`
+
+func TestAppRunPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, PluginPrefix+"kv"), []byte(testPluginScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp()
+	defer app.Close()
+	app.PluginPaths = []string{dir, filepath.Join(dir, "notfound")}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+kv "unchanged" {
+    value = "old",
+}
+kv "changed" {
+    value = "new",
+    notifies = {"nothing", "kv[unchanged]"},
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "action.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{`"action":"set"`, `"name":"changed"`, `"key":"changed"`, `"value":"new"`, `"current_attributes":{"value":"old"}`} {
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("the request must contain %s but got %s", expected, string(b))
+		}
+	}
+}
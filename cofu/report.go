@@ -0,0 +1,199 @@
+package cofu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Report is a machine-readable result of a converging.
+type Report struct {
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Duration   float64           `json:"duration"`
+	DryRun     bool              `json:"dry_run"`
+	Resources  []*ResourceReport `json:"resources"`
+	Error      string            `json:"error,omitempty"`
+	mutex      sync.Mutex
+}
+
+// ResourceReport is a result of a resource evaluation.
+// If the resource is evaluated several times by notifications, the report has an entry for each evaluation.
+type ResourceReport struct {
+	Resource       string                `json:"resource"`
+	Type           string                `json:"type"`
+	Name           string                `json:"name"`
+	Role           string                `json:"role,omitempty"`
+	Actions        []string              `json:"actions"`
+	Updated        bool                  `json:"updated"`
+	Drifted        bool                  `json:"drifted"`
+	Differences    []*Difference         `json:"differences"`
+	Skipped        bool                  `json:"skipped"`
+	SkippedReason  string                `json:"skipped_reason,omitempty"`
+	Duration       float64               `json:"duration"`
+	Notifications  []*NotificationReport `json:"notifications"`
+	Error          string                `json:"error,omitempty"`
+	FailureIgnored bool                  `json:"failure_ignored"`
+	startedAt      time.Time
+}
+
+// Difference is a difference between the current attribute and the attribute defined in the recipe.
+type Difference struct {
+	Attribute string      `json:"attribute"`
+	Current   interface{} `json:"current"`
+	Desired   interface{} `json:"desired"`
+}
+
+type NotificationReport struct {
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Timing string `json:"timing"`
+}
+
+func NewReport(dryRun bool) *Report {
+	return &Report{
+		StartedAt: time.Now(),
+		DryRun:    dryRun,
+		Resources: []*ResourceReport{},
+	}
+}
+
+func (report *Report) addResource(r *Resource) *ResourceReport {
+	if report == nil {
+		return nil
+	}
+
+	rr := &ResourceReport{
+		Resource:      r.Desc(),
+		Type:          r.ResourceType.Name,
+		Name:          r.Name,
+		Role:          r.Role,
+		Actions:       []string{},
+		Differences:   []*Difference{},
+		Notifications: []*NotificationReport{},
+		startedAt:     time.Now(),
+	}
+
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	report.Resources = append(report.Resources, rr)
+
+	return rr
+}
+
+// merge appends the resource reports of the child app.
+func (report *Report) merge(child *Report) {
+	if report == nil || child == nil {
+		return
+	}
+
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	report.Resources = append(report.Resources, child.Resources...)
+}
+
+func (report *Report) finish(err error) {
+	report.FinishedAt = time.Now()
+	report.Duration = report.FinishedAt.Sub(report.StartedAt).Seconds()
+	if err != nil {
+		report.Error = err.Error()
+	}
+}
+
+func (report *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return report.WriteJSON(w)
+	default:
+		return fmt.Errorf("unsupported report format '%s'", format)
+	}
+}
+
+func (report *Report) WriteJSON(w io.Writer) error {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func (rr *ResourceReport) addAction(action string) {
+	if rr == nil {
+		return
+	}
+	rr.Actions = append(rr.Actions, action)
+}
+
+func (rr *ResourceReport) addDifference(key string, currentValue, value interface{}) {
+	if rr == nil {
+		return
+	}
+	rr.Differences = append(rr.Differences, &Difference{
+		Attribute: key,
+		Current:   currentValue,
+		Desired:   value,
+	})
+}
+
+// truncateDifferences drops the differences after the first n ones.
+// It is used to discard the differences that were recorded by a failed attempt of the action.
+func (rr *ResourceReport) truncateDifferences(n int) {
+	if rr == nil || len(rr.Differences) <= n {
+		return
+	}
+	rr.Differences = rr.Differences[:n]
+}
+
+func (rr *ResourceReport) addNotification(n *Notification) {
+	if rr == nil {
+		return
+	}
+	rr.Notifications = append(rr.Notifications, &NotificationReport{
+		Action: n.Action,
+		Target: n.TargetResourceDesc,
+		Timing: n.Timing,
+	})
+}
+
+func (rr *ResourceReport) drift() {
+	if rr == nil {
+		return
+	}
+	rr.Drifted = true
+}
+
+func (rr *ResourceReport) skip(reason string) {
+	if rr == nil {
+		return
+	}
+	rr.Skipped = true
+	rr.SkippedReason = reason
+}
+
+func (rr *ResourceReport) ignoreFailure(err error) {
+	if rr == nil {
+		return
+	}
+	rr.Error = err.Error()
+	rr.FailureIgnored = true
+}
+
+func (rr *ResourceReport) finish(updated bool, err error) {
+	if rr == nil {
+		return
+	}
+	rr.Updated = updated
+	rr.Duration = time.Since(rr.startedAt).Seconds()
+	if err != nil {
+		rr.Error = err.Error()
+	}
+}
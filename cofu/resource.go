@@ -4,10 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/kohkimakimoto/cofu/infra"
 	"github.com/kohkimakimoto/cofu/infra/backend"
@@ -17,6 +18,10 @@ import (
 	"github.com/yuin/gopher-lua"
 )
 
+// DefaultRetryDelay is a delay before the first retry of a failed action.
+// The delay doubles on each retry.
+var DefaultRetryDelay = 2 * time.Second
+
 type Resource struct {
 	Name string
 	// Basepath is a directory path that includes recipe file defines this resource.
@@ -30,7 +35,23 @@ type Resource struct {
 	App                *App
 	CurrentAction      string
 	Values             map[string]interface{}
-	updated            bool
+	// Location is a place in the recipe that defines this resource, like 'recipe.lua:3:'.
+	Location string
+	// Role is the name of the role whose run-list defines this resource.
+	Role    string
+	updated bool
+	// logger is used instead of the app logger while the resource is converged in parallel.
+	logger Logger
+	// report is a report of the current evaluation.
+	report *ResourceReport
+	// excluded is true if the resource is filtered out by tags.
+	excluded bool
+	// drifted is true if the resource's attributes differ from the current attributes.
+	drifted bool
+	// lazyAttributes are functions that return the attribute values at converge time.
+	lazyAttributes map[string]*lua.LFunction
+	// runLock is held while the resource is converged in parallel mode.
+	runLock chan struct{}
 }
 
 func NewResource(name string, resourceType *ResourceType, app *App) *Resource {
@@ -50,6 +71,8 @@ func NewResource(name string, resourceType *ResourceType, app *App) *Resource {
 		AttributesLValues:  map[string]lua.LValue{},
 		CurrentAttributes:  map[string]interface{}{},
 		FallbackAttributes: map[string]interface{}{},
+		lazyAttributes:     map[string]*lua.LFunction{},
+		runLock:            make(chan struct{}, 1),
 		ResourceType:       resourceType,
 		App:                app,
 		Basepath:           basepath,
@@ -57,6 +80,33 @@ func NewResource(name string, resourceType *ResourceType, app *App) *Resource {
 	}
 }
 
+func (r *Resource) lockRun() {
+	r.runLock <- struct{}{}
+}
+
+// tryLockRun returns false if the resource is being converged.
+func (r *Resource) tryLockRun() bool {
+	select {
+	case r.runLock <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Resource) unlockRun() {
+	<-r.runLock
+}
+
+// Logger returns a logger for the resource.
+func (r *Resource) Logger() Logger {
+	if r.logger != nil {
+		return r.logger
+	}
+
+	return r.App.Logger
+}
+
 // Desc returns string like 'resource_type[name]'
 func (r *Resource) Desc() string {
 	return fmt.Sprintf("%s[%s]", r.ResourceType.Name, r.Name)
@@ -242,8 +292,8 @@ func (r *Resource) GetLFunctionCurrentAttribute(key string) *lua.LFunction {
 	}
 }
 
-func (r *Resource) Run(specificAction string) error {
-	logger := r.App.Logger
+func (r *Resource) Run(specificAction string) (err error) {
+	logger := r.Logger()
 	r.updated = false
 
 	var actions []string
@@ -258,36 +308,154 @@ func (r *Resource) Run(specificAction string) error {
 		return nil
 	}
 
+	r.report = r.App.Report.addResource(r)
+	defer func() {
+		r.report.finish(r.updated, err)
+		r.updated = false
+	}()
+	defer func() {
+		// RunCommand panics if a command of the guards or verify times out.
+		if e := recover(); e != nil {
+			err = r.handleFailure(fmt.Errorf("%v", e))
+		}
+	}()
+
+	r.App.emit(&Event{Type: EventResourceStarted, Resource: r, Action: specificAction})
+
 	if loglv.IsInfo() {
+		role := ""
+		if r.Role != "" {
+			role = fmt.Sprintf(" (role: %s)", r.Role)
+		}
+
 		description := r.GetStringAttribute("description")
 		if description != "" {
-			logger.Info(color.FgBold(fmt.Sprintf("Evaluating %s: %s", r.Desc(), description)))
+			logger.Info(color.FgBold(fmt.Sprintf("Evaluating %s: %s%s", r.Desc(), description, role)))
 		} else {
-			logger.Info(color.FgBold(fmt.Sprintf("Evaluating %s", r.Desc())))
+			logger.Info(color.FgBold(fmt.Sprintf("Evaluating %s%s", r.Desc(), role)))
 		}
 	}
 
 	logger.Debugf("Resource basepath: %s", r.Basepath)
 
-	err := os.Chdir(r.Basepath)
-	if err != nil {
-		return err
-	}
+	// The current directory is shared by all goroutines.
+	// In parallel mode, commands are run on the basepath by the command option instead.
+	if !r.App.isParallel() {
+		err := os.Chdir(r.Basepath)
+		if err != nil {
+			return err
+		}
 
-	logger.Debugf("Changed current directory: %s", r.Basepath)
+		logger.Debugf("Changed current directory: %s", r.Basepath)
+	}
 
-	if r.doNotRunBecauseOfOnlyIf() {
+	if skip, err := r.doNotRunBecauseOfOnlyIf(); err != nil {
+		return r.handleFailure(err)
+	} else if skip {
 		logger.Info("Execution skipped because of only_if attribute.")
+		r.report.skip("only_if")
+		r.App.emit(&Event{Type: EventResourceSkipped, Resource: r, Reason: "only_if"})
 		return nil
 	}
 
-	if r.doNotRunBecauseOfNotIf() {
+	if skip, err := r.doNotRunBecauseOfNotIf(); err != nil {
+		return r.handleFailure(err)
+	} else if skip {
 		logger.Info("Execution skipped because of not_if attribute.")
+		r.report.skip("not_if")
+		r.App.emit(&Event{Type: EventResourceSkipped, Resource: r, Reason: "not_if"})
 		return nil
 	}
 
+	if err := r.evaluateLazyAttributes(); err != nil {
+		return err
+	}
+
+	if err := r.converge(actions); err != nil {
+		return r.handleFailure(err)
+	}
+
+	if r.updated {
+		if err := r.notify(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleFailure returns nil if the failure is ignored by ignore_failure attribute.
+func (r *Resource) handleFailure(err error) error {
+	if !r.GetBoolAttribute("ignore_failure") {
+		return err
+	}
+
+	r.Logger().Error(color.FgRB("%s: %v (ignored because of ignore_failure attribute)", r.Desc(), err))
+	r.report.ignoreFailure(err)
+	return nil
+}
+
+// evaluateLazyAttributes calls the functions set to the attributes and sets the results as the attribute values.
+func (r *Resource) evaluateLazyAttributes() error {
+	if len(r.lazyAttributes) == 0 {
+		return nil
+	}
+
+	names := []string{}
+	for name := range r.lazyAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return r.CallLState(func(L *lua.LState) error {
+		for _, name := range names {
+			if err := L.CallByParam(lua.P{
+				Fn:      r.lazyAttributes[name],
+				NRet:    1,
+				Protect: true,
+			}, newLResource(L, r)); err != nil {
+				return fmt.Errorf("%s: failed to evaluate '%s' attribute: %v", r.Desc(), name, err)
+			}
+
+			ret := L.Get(-1)
+			L.Pop(1)
+
+			goValue, err := r.lazyAttributeValue(name, ret)
+			if err != nil {
+				return err
+			}
+
+			r.Logger().Debugf("%s: Evaluated '%s' attribute: %v", r.Desc(), name, r.maskAttributeValue(name, goValue))
+			r.Attributes[name] = goValue
+			r.AttributesLValues[name] = ret
+		}
+
+		return nil
+	})
+}
+
+// lazyAttributeValue converts the result of the function by the attribute's ToGoValue.
+func (r *Resource) lazyAttributeValue(name string, lv lua.LValue) (v interface{}, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%s: failed to evaluate '%s' attribute: %v", r.Desc(), name, e)
+		}
+	}()
+
+	attribute := r.ResourceType.findAttribute(name)
+	if err := attribute.Validate(lv); err != nil {
+		return nil, fmt.Errorf("%s: failed to evaluate '%s' attribute: %v", r.Desc(), name, err)
+	}
+
+	return attribute.ToGoValue(lv), nil
+}
+
+func (r *Resource) converge(actions []string) error {
 	for _, action := range actions {
-		if err := r.runAction(action); err != nil {
+		r.report.addAction(action)
+		err := r.runActionWithRetries(action)
+		r.App.emit(&Event{Type: EventActionFinished, Resource: r, Action: action, Error: err})
+		if err != nil {
 			return err
 		}
 	}
@@ -299,19 +467,59 @@ func (r *Resource) Run(specificAction string) error {
 		}
 	}
 
-	if r.updated {
-		if err := r.notify(); err != nil {
+	return nil
+}
+
+func (r *Resource) runActionWithRetries(action string) error {
+	logger := r.Logger()
+
+	retries := 0
+	if v := r.GetIntegerAttribute("retries"); v != nil && !v.Nil() {
+		retries = v.V
+	}
+
+	delay := DefaultRetryDelay
+	if v := r.GetIntegerAttribute("retry_delay"); v != nil && !v.Nil() {
+		delay = time.Duration(v.V) * time.Second
+	}
+
+	// the differences of the previous actions.
+	differences := 0
+	if r.report != nil {
+		differences = len(r.report.Differences)
+	}
+
+	for i := 1; ; i++ {
+		// each attempt records the differences again.
+		r.report.truncateDifferences(differences)
+
+		err := r.runAction(action)
+		if err == nil || i > retries {
+			r.emitDifferences(differences)
 			return err
 		}
+
+		logger.Warnf("%s: action '%s' failed: %v. Retrying in %v (%d/%d)", r.Desc(), action, err, delay, i, retries)
+		time.Sleep(delay)
+
+		// back-off
+		delay *= 2
 	}
+}
 
-	r.updated = false
+// emitDifferences emits the differences that were recorded by the final attempt of the action.
+func (r *Resource) emitDifferences(from int) {
+	if r.report == nil {
+		return
+	}
 
-	return nil
+	for _, d := range r.report.Differences[from:] {
+		r.App.emit(&Event{Type: EventDifference, Resource: r, Attribute: d.Attribute, Current: d.Current, Desired: d.Desired})
+	}
 }
 
 func (r *Resource) verify() error {
-	logger := r.App.Logger
+	logger := r.Logger()
 	commands := r.GetStringSliceAttribute("verify")
 	if commands == nil {
 		return nil
@@ -322,7 +530,7 @@ func (r *Resource) verify() error {
 	for _, c := range commands {
 		ret := r.RunCommand(c)
 		if ret.Failure() {
-			return fmt.Errorf("Verifying command '%s' failed with status '%d'. %s", c, ret.ExitStatus, ret.Stderr.String())
+			return fmt.Errorf("Verifying command '%s' failed with status '%d'. %s", r.Redact(c), ret.ExitStatus, r.Redact(ret.Stderr.String()))
 		}
 	}
 
@@ -330,7 +538,7 @@ func (r *Resource) verify() error {
 }
 
 func (r *Resource) notify() error {
-	logger := r.App.Logger
+	logger := r.Logger()
 
 	for _, n := range r.Notifications {
 		message := fmt.Sprintf("%s: Notifying %s to %s", r.Desc(), n.Action, n.TargetResourceDesc)
@@ -341,9 +549,11 @@ func (r *Resource) notify() error {
 		}
 
 		logger.Info(message)
+		r.report.addNotification(n)
 
 		if n.Delayed() {
 			r.App.EnqueueDelayedNotification(n)
+			r.App.emit(&Event{Type: EventNotificationQueued, Resource: r, Action: n.Action, Notification: n})
 		} else if n.Immediately() {
 			if err := n.Run(); err != nil {
 				return err
@@ -358,8 +568,15 @@ func (r *Resource) clearCurrentAttributes() {
 	r.CurrentAttributes = map[string]interface{}{}
 }
 
-func (r *Resource) runAction(action string) error {
-	logger := r.App.Logger
+func (r *Resource) runAction(action string) (err error) {
+	defer func() {
+		// MustRunCommand panics if the command fails.
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+
+	logger := r.Logger()
 
 	resourceType := r.ResourceType
 	actionFunc, ok := resourceType.Actions[action]
@@ -402,13 +619,28 @@ func (r *Resource) runAction(action string) error {
 		logger.Debugf("Finished '%s' ShowDifferences", r.Desc())
 	}
 
+	if resourceType.Composite {
+		logger.Debugf("Processing '%s' action: '%s'", r.Desc(), action)
+
+		if err := actionFunc(r); err != nil {
+			return err
+		}
+
+		logger.Debugf("Finished '%s' action: '%s'", r.Desc(), action)
+
+		return nil
+	}
+
 	if !r.different() {
 		// run action only if the attributes change.
 		logger.Debugf("There are not attributes to change '%s'", r.Desc())
 		return nil
 	}
 
-	if !r.App.DryRun || r.ResourceType.Name == "resource" {
+	r.drifted = true
+	r.report.drift()
+
+	if !r.App.DryRun {
 		logger.Debugf("Processing '%s' action: '%s'", r.Desc(), action)
 
 		err := actionFunc(r)
@@ -427,7 +659,7 @@ func (r *Resource) runAction(action string) error {
 // different returns true if the resource's attributes different with current attributes.
 // see also DefaultShowDifferences
 func (r *Resource) different() bool {
-	logger := r.App.Logger
+	logger := r.Logger()
 
 	logger.Debugf("Checking difference of '%s'", r.Desc())
 
@@ -461,7 +693,7 @@ func (r *Resource) different() bool {
 			}
 		}
 
-		logger.Debugf("Checking difference '%s' (currentAttr: '%v') => (attr: '%v')", key, currentValue, value)
+		logger.Debugf("Checking difference '%s' (currentAttr: '%v') => (attr: '%v')", key, r.maskAttributeValue(key, currentValue), r.maskAttributeValue(key, value))
 
 		if currentValue == nil || value == nil {
 			// ignore
@@ -482,28 +714,63 @@ func (r *Resource) different() bool {
 	return false
 }
 
-func (r *Resource) doNotRunBecauseOfOnlyIf() bool {
-	command := r.GetStringAttribute("only_if")
-	if command == "" {
-		return false
+func (r *Resource) doNotRunBecauseOfOnlyIf() (bool, error) {
+	if v := r.GetRawAttribute("only_if"); v == nil || v == "" {
+		return false, nil
 	}
 
-	return r.RunCommand(command).ExitStatus != 0
+	ok, err := r.evaluateGuard("only_if")
+	return !ok, err
 }
 
-func (r *Resource) doNotRunBecauseOfNotIf() bool {
-	command := r.GetStringAttribute("not_if")
-	if command == "" {
-		return false
+func (r *Resource) doNotRunBecauseOfNotIf() (bool, error) {
+	if v := r.GetRawAttribute("not_if"); v == nil || v == "" {
+		return false, nil
 	}
 
-	return r.RunCommand(command).ExitStatus == 0
+	ok, err := r.evaluateGuard("not_if")
+	return ok, err
+}
+
+// evaluateGuard returns true if the guard command exits with zero status,
+// or the guard function returns true.
+func (r *Resource) evaluateGuard(key string) (bool, error) {
+	switch v := r.GetRawAttribute(key).(type) {
+	case string:
+		return r.RunCommand(v).ExitStatus == 0, nil
+	case *lua.LFunction:
+		var ret bool
+		err := r.CallLState(func(L *lua.LState) error {
+			if err := L.CallByParam(lua.P{
+				Fn:      v,
+				NRet:    1,
+				Protect: true,
+			}, newLResource(L, r)); err != nil {
+				return err
+			}
+
+			ret = lua.LVAsBool(L.Get(-1))
+			L.Pop(1)
+			return nil
+		})
+		if err != nil {
+			return false, fmt.Errorf("%s: %s function failed: %v", r.Desc(), key, err)
+		}
+
+		r.Logger().Debugf("%s function returned %v", key, ret)
+		return ret, nil
+	default:
+		return false, fmt.Errorf("%s: '%s' is not supported value type %v. it should be as a string or a function.", r.Desc(), key, v)
+	}
 }
 
 func (r *Resource) MustRunCommand(command string) *backend.CommandResult {
 	ret := r.RunCommand(command)
 	if ret.ExitStatus != 0 {
-		panic(ret.Combined.String())
+		if r.IsSensitive() {
+			panic(fmt.Sprintf("command failed with exit status %d (the output is hidden because the resource is sensitive)", ret.ExitStatus))
+		}
+		panic(r.Redact(ret.Combined.String()))
 	}
 
 	return ret
@@ -514,19 +781,133 @@ func (r *Resource) CheckCommand(command string) bool {
 }
 
 func (r *Resource) RunCommand(command string) *backend.CommandResult {
-	logger := r.App.Logger
+	logger := r.Logger()
 
 	opt := &backend.CommandOption{
-		User: r.GetStringAttribute("user"),
-		Cwd:  r.GetStringAttribute("cwd"),
+		User:    r.GetStringAttribute("user"),
+		Cwd:     r.GetStringAttribute("cwd"),
+		Dir:     r.Basepath,
+		Timeout: r.commandTimeout(),
 	}
 
 	i := r.Infra()
-	command = i.BuildCommand(command, opt)
+	builtCommand := i.BuildCommand(command, opt)
+
+	if r.IsSensitive() {
+		logger.Debugf("command: (hidden because the resource is sensitive)")
+	} else {
+		logger.Debugf("command: %s", r.Redact(builtCommand))
+	}
+
+	ret := i.RunCommandWithOption(builtCommand, opt)
+	if ret.TimedOut {
+		panic(fmt.Errorf("%s: command '%s' timed out after %v and was killed", r.Desc(), r.Redact(command), opt.Timeout))
+	}
+
+	return ret
+}
+
+// IsSensitive returns true if the resource has 'sensitive = true'.
+func (r *Resource) IsSensitive() bool {
+	return r.GetBoolAttribute("sensitive")
+}
+
+// IsSensitiveAttribute returns true if the value of the attribute must not be shown.
+func (r *Resource) IsSensitiveAttribute(name string) bool {
+	if r.IsSensitive() {
+		return true
+	}
 
-	logger.Debugf("command: %s", command)
+	switch a := r.ResourceType.findAttribute(name).(type) {
+	case *StringAttribute:
+		return a.IsSensitive()
+	case *MapAttribute:
+		return a.IsSensitive()
+	}
 
-	return i.RunCommand(command)
+	return false
+}
+
+// Redact replaces the values of the sensitive attributes and the secrets in the s with SecretMask.
+func (r *Resource) Redact(s string) string {
+	values := []string{}
+	for name, v := range r.Attributes {
+		if !r.IsSensitiveAttribute(name) {
+			continue
+		}
+
+		switch converted := v.(type) {
+		case string:
+			values = append(values, converted)
+		case map[string]interface{}:
+			for _, item := range converted {
+				if str, ok := item.(string); ok {
+					values = append(values, str)
+				}
+			}
+		}
+	}
+
+	// replace the longer ones first not to leave a part of them.
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		s = strings.Replace(s, util.ShellEscape(v), util.ShellEscape(SecretMask), -1)
+		s = strings.Replace(s, v, SecretMask, -1)
+	}
+
+	return r.App.MaskSecrets(s)
+}
+
+// maskAttributeValue masks the value of the attribute for showing it.
+func (r *Resource) maskAttributeValue(name string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	if r.IsSensitiveAttribute(name) {
+		return SecretMask
+	}
+
+	return r.App.maskSecretValue(v)
+}
+
+// commandTimeout returns the timeout attribute, or the default timeout of the app.
+func (r *Resource) commandTimeout() time.Duration {
+	if v := r.GetIntegerAttribute("timeout"); v != nil && !v.Nil() {
+		return time.Duration(v.V) * time.Second
+	}
+
+	return r.App.CommandTimeout
+}
+
+// CallLState calls fn with the LState of the app.
+// See also App.CallLState
+func (r *Resource) CallLState(fn func(L *lua.LState) error) error {
+	return r.App.CallLState(func(L *lua.LState) error {
+		if r.App.isParallel() {
+			// lua code may use relative paths from the recipe.
+			if err := os.Chdir(r.Basepath); err != nil {
+				return err
+			}
+		}
+
+		return fn(L)
+	})
+}
+
+// Path returns an absolute path. A relative path is joined to the basepath of the resource.
+func (r *Resource) Path(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+
+	return filepath.Join(r.Basepath, p)
 }
 
 func (r *Resource) SendContentToTempfile(content []byte) (string, error) {
@@ -568,11 +949,16 @@ func (r *Resource) IsDifferentFilesRecursively(from, to string) bool {
 }
 
 func (r *Resource) ShowContentDiff(from, to string) {
-	logger := r.App.Logger
+	logger := r.Logger()
 	diff := fmt.Sprintf("diff -u %s %s", util.ShellEscape(from), util.ShellEscape(to))
 
 	logger.Debugf("diff: %s", diff)
 
+	if r.IsSensitiveAttribute("content") {
+		logger.Info("(the diff is hidden because the resource is sensitive)")
+		return
+	}
+
 	stdout := r.RunCommand(diff).Stdout
 	// I intentionally doesn't use bufio.Scanner to prevent bufio.Scanner: token too long
 	// see https://github.com/kohkimakimoto/cofu/issues/18
@@ -584,7 +970,7 @@ func (r *Resource) ShowContentDiff(from, to string) {
 		} else if err != nil {
 			panic(err)
 		}
-		line := string(linebytes)
+		line := r.Redact(string(linebytes))
 
 		if strings.HasPrefix(line, "+") {
 			logger.Info(color.FgG(" %s", line))
@@ -597,12 +983,17 @@ func (r *Resource) ShowContentDiff(from, to string) {
 }
 
 func (r *Resource) ShowContentDiffRecursively(from, to string) {
-	logger := r.App.Logger
+	logger := r.Logger()
 
 	diff := fmt.Sprintf("diff -r -u %s %s", util.ShellEscape(from), util.ShellEscape(to))
 
 	logger.Debugf("diff: %s", diff)
 
+	if r.IsSensitiveAttribute("content") {
+		logger.Info("(the diff is hidden because the resource is sensitive)")
+		return
+	}
+
 	stdout := r.RunCommand(diff).Stdout
 	reader := bufio.NewReader(&stdout)
 	for {
@@ -612,16 +1003,14 @@ func (r *Resource) ShowContentDiffRecursively(from, to string) {
 		} else if err != nil {
 			panic(err)
 		}
-		line := string(linebytes)
+		line := r.Redact(string(linebytes))
 
-		if loglv.IsInfo() {
-			if strings.HasPrefix(line, "+") {
-				log.Print(color.FgG(" %s", line))
-			} else if strings.HasPrefix(line, "-") {
-				log.Print(color.FgR(" %s", line))
-			} else {
-				log.Printf("%s", line)
-			}
+		if strings.HasPrefix(line, "+") {
+			logger.Info(color.FgG(" %s", line))
+		} else if strings.HasPrefix(line, "-") {
+			logger.Info(color.FgR(" %s", line))
+		} else {
+			logger.Infof("%s", line)
 		}
 	}
 }
@@ -631,7 +1020,7 @@ func (r *Resource) Infra() *infra.Infra {
 }
 
 func (r *Resource) Update() {
-	logger := r.App.Logger
+	logger := r.Logger()
 
 	if r.updated {
 		return
@@ -645,8 +1034,22 @@ func (r Resource) IsUpdated() bool {
 	return r.updated
 }
 
+func (r *Resource) IsExcluded() bool {
+	return r.excluded
+}
+
+// HasLazyAttribute returns true if the attribute is a function evaluated at converge time.
+func (r *Resource) HasLazyAttribute(name string) bool {
+	_, ok := r.lazyAttributes[name]
+	return ok
+}
+
+func (r Resource) IsDrifted() bool {
+	return r.drifted
+}
+
 func DefaultShowDifferences(r *Resource) error {
-	logger := r.App.Logger
+	logger := r.Logger()
 	// for constant order
 	var keys []string
 	for key, _ := range r.CurrentAttributes {
@@ -674,15 +1077,18 @@ func DefaultShowDifferences(r *Resource) error {
 			}
 		}
 
+		shownCurrentValue, shownValue := r.maskAttributeValue(key, currentValue), r.maskAttributeValue(key, value)
+
 		if currentValue == nil || value == nil {
 			// ignore
 		} else if currentValue == nil && value != nil {
-			logger.Info(color.FgGB("%s: '%s' will be '%v'", r.Desc(), key, value))
+			logger.Info(color.FgGB("%s: '%s' will be '%v'", r.Desc(), key, shownValue))
 		} else if currentValue == value || value == nil {
 			// ignore. not change
-			logger.Debugf("%s: %s will not change (current value is '%v')", r.Desc(), key, currentValue)
+			logger.Debugf("%s: %s will not change (current value is '%v')", r.Desc(), key, shownCurrentValue)
 		} else {
-			logger.Info(color.FgGB("%s: '%s' will change from '%v' to '%v'", r.Desc(), key, currentValue, value))
+			logger.Info(color.FgGB("%s: '%s' will change from '%v' to '%v'", r.Desc(), key, shownCurrentValue, shownValue))
+			r.report.addDifference(key, shownCurrentValue, shownValue)
 		}
 	}
 
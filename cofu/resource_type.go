@@ -3,6 +3,7 @@ package cofu
 import (
 	"fmt"
 	"github.com/yuin/gopher-lua"
+	"sort"
 )
 
 type ResourceType struct {
@@ -13,7 +14,16 @@ type ResourceType struct {
 	Actions                  map[string]ResourceAction
 	ShowDifferences          ResourceAction
 	UseFallbackAttributes    bool
-	app                      *App
+	// Composite is true if the actions converge other resources in a child app.
+	// The actions run even in dry-run mode and update the resource only when the inner resources are updated.
+	Composite bool
+	// ValidateFunc checks the resource without side effects. It is used by App.Validate.
+	ValidateFunc ResourceAction
+	// unresolved is true if the attributes and actions are unknown, like the plugins in validating mode.
+	unresolved bool
+	// declaredInLua is true if the resource type is declared by resource_type in a recipe.
+	declaredInLua bool
+	app           *App
 }
 
 func (resourceType *ResourceType) LGFunction() func(L *lua.LState) int {
@@ -30,7 +40,7 @@ func (resourceType *ResourceType) LGFunction() func(L *lua.LState) int {
 			// function style
 			tb := L.CheckTable(2)
 			r := resourceType.registerResource(L, name)
-			setupResource(r, tb)
+			setupResource(L, r, tb)
 			L.Push(newLResource(L, r))
 
 			return 1
@@ -48,6 +58,8 @@ func (resourceType *ResourceType) registerResource(L *lua.LState, name string) *
 	}
 
 	r := NewResource(name, resourceType, app)
+	r.Location = L.Where(1)
+	r.Role = app.currentRole
 
 	// set default attributes
 	for _, definedAttribute := range resourceType.Attributes {
@@ -83,39 +95,98 @@ func loadLResourceClass(L *lua.LState) {
 	L.SetField(mt, "__newindex", L.NewFunction(resourceNewindex))
 }
 
-func updateResource(r *Resource, attributeName string, value lua.LValue) {
-	var attribute Attribute
-	for _, definedAttribute := range r.ResourceType.Attributes {
-		if definedAttribute.GetName() == attributeName {
-			attribute = definedAttribute
-			break
+func (resourceType *ResourceType) findAttribute(name string) Attribute {
+	for _, definedAttribute := range resourceType.Attributes {
+		if definedAttribute.GetName() == name {
+			return definedAttribute
 		}
 	}
 
+	return nil
+}
+
+// updateResource sets the attribute value after validating it.
+func updateResource(r *Resource, attributeName string, value lua.LValue) error {
+	attribute := r.ResourceType.findAttribute(attributeName)
+
+	if fn, ok := value.(*lua.LFunction); ok && attribute != nil && isLazyAttribute(attribute) {
+		// the function is evaluated at converge time. see Resource.evaluateLazyAttributes
+		r.lazyAttributes[attributeName] = fn
+		r.AttributesLValues[attributeName] = value
+		return nil
+	}
+	delete(r.lazyAttributes, attributeName)
+
 	var goValue interface{}
 	if attribute == nil {
 		if r.ResourceType.UseFallbackAttributes {
 			goValue = toGoValue(value)
 			r.FallbackAttributes[attributeName] = goValue
 		} else {
-			panic(fmt.Sprintf("Invalid attribute name '%s'.", attributeName))
+			return fmt.Errorf("%s: Invalid attribute name '%s'.", r.Desc(), attributeName)
 		}
 	} else {
+		if err := attribute.Validate(value); err != nil {
+			return fmt.Errorf("%s: %v", r.Desc(), err)
+		}
 		goValue = attribute.ToGoValue(value)
 	}
 
 	r.AttributesLValues[attributeName] = value
 	r.Attributes[attributeName] = goValue
+
+	return nil
 }
 
-func setupResource(r *Resource, attributes *lua.LTable) {
+// isLazyAttribute returns true if the attribute accepts a function that returns the value at converge time.
+// The attributes that take a function as the value itself or are used before converging do not.
+// The common attributes that are used while evaluating only_if and not_if do not either.
+func isLazyAttribute(attribute Attribute) bool {
+	switch attribute.(type) {
+	case *LFunctionAttribute, *GuardAttribute, *NotifiesAttribute:
+		return false
+	}
+
+	switch attribute.GetName() {
+	case "action", "depends_on", "tags",
+		"user", "cwd", "timeout", "sensitive", "retries", "retry_delay", "ignore_failure", "description":
+		return false
+	}
+
+	return true
+}
+
+// setupResource sets the attributes. It raises an error that has the location in the recipe if an attribute is invalid.
+func setupResource(L *lua.LState, r *Resource, attributes *lua.LTable) {
+	errs := []error{}
 	attributes.ForEach(func(k, v lua.LValue) {
 		if kstr, ok := toString(k); ok {
-			updateResource(r, kstr, v)
+			if err := updateResource(r, kstr, v); err != nil {
+				errs = append(errs, err)
+			}
 		} else {
-			panic(fmt.Sprintf("'%s' An attribute must be string", r.Desc()))
+			errs = append(errs, fmt.Errorf("'%s' An attribute must be string", r.Desc()))
 		}
 	})
+
+	// report errors in a stable order.
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+
+	for _, err := range errs {
+		raiseOrRecord(L, r.App, err)
+	}
+}
+
+// raiseOrRecord raises the error, or records it to report all errors at once in validating mode.
+func raiseOrRecord(L *lua.LState, app *App, err error) {
+	if app.Validating {
+		app.addValidationError(L.Where(1), err)
+		return
+	}
+
+	L.RaiseError("%s", err.Error())
 }
 
 func newLResource(L *lua.LState, r *Resource) *lua.LUserData {
@@ -140,7 +211,7 @@ func resourceCall(L *lua.LState) int {
 	r := checkResource(L)
 	tb := L.CheckTable(2)
 
-	setupResource(r, tb)
+	setupResource(L, r, tb)
 
 	return 0
 }
@@ -151,7 +222,12 @@ func resourceIndex(L *lua.LState) int {
 
 	v, ok := r.AttributesLValues[index]
 	if v == nil || !ok {
-		v = lua.LNil
+		if r.ResourceType.declaredInLua {
+			// the actions of the resource types declared in Lua read the default values.
+			v = toLValue(L, r.Attributes[index])
+		} else {
+			v = lua.LNil
+		}
 	}
 
 	L.Push(v)
@@ -163,7 +239,9 @@ func resourceNewindex(L *lua.LState) int {
 	index := L.CheckString(2)
 	value := L.CheckAny(3)
 
-	updateResource(r, index, value)
+	if err := updateResource(r, index, value); err != nil {
+		raiseOrRecord(L, r.App, err)
+	}
 
 	return 0
 }
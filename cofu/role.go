@@ -0,0 +1,217 @@
+package cofu
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RolesDir is the directory that has the role files. It is next to the main recipe.
+const RolesDir = "roles"
+
+// Role is a run-list of recipes and its default variables.
+type Role struct {
+	Name string
+	// File is the role file like 'roles/web.yml'.
+	File string
+	// Basepath is a directory that the recipes in the run-list are resolved from.
+	Basepath string
+	RunList  []string
+	Vars     map[string]interface{}
+}
+
+// LoadRoles loads the role files in the roles directory of the dir and merges their variables into 'var'.
+// The variables of the later roles take precedence over the earlier ones.
+func (app *App) LoadRoles(dir string, names []string) ([]*Role, error) {
+	roles := []*Role{}
+	for _, name := range names {
+		role, err := app.loadRole(dir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := app.LoadVariableFromMap(role.Vars); err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// LoadRunList loads the recipes in the run-lists of the roles in order.
+// The resources defined by the recipes have the role name.
+func (app *App) LoadRunList(roles []*Role) error {
+	defer func() {
+		app.currentRole = ""
+	}()
+
+	for _, role := range roles {
+		app.currentRole = role.Name
+		app.Logger.Debugf("Loading the run-list of role '%s'", role.Name)
+
+		for _, recipe := range role.RunList {
+			if strings.HasPrefix(recipe, BuiltinRecipePrefix) {
+				if app.loadedRecipes[recipe] {
+					continue
+				}
+				app.loadedRecipes[recipe] = true
+
+				if err := app.LoadBuiltinRecipe(strings.TrimPrefix(recipe, BuiltinRecipePrefix)); err != nil {
+					return fmt.Errorf("role '%s': %v", role.Name, err)
+				}
+				continue
+			}
+
+			path, err := resolveIncludedRecipe(app, recipe, role.Basepath)
+			if err == ErrSourceNotFetched {
+				app.Logger.Warnf("role '%s': '%s' is not validated because %v.", role.Name, app.MaskSecrets(recipe), err)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("role '%s': %v", role.Name, err)
+			}
+			if !strings.HasSuffix(path, ".lua") {
+				path += ".lua"
+			}
+
+			key := recipeKey(path)
+			if app.loadedRecipes[key] {
+				// the recipe is shared by the roles.
+				app.Logger.Debugf("Skipped '%s' because it is already loaded.", path)
+				continue
+			}
+			app.loadedRecipes[key] = true
+
+			if err := loadRecipeFile(path, app.LState, app); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (app *App) loadRole(dir, name string) (*Role, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid role name '%s'", name)
+	}
+
+	for _, ext := range []string{".lua", ".yml", ".yaml"} {
+		file := filepath.Join(dir, RolesDir, name+ext)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+
+		role := &Role{
+			Name:     name,
+			File:     file,
+			Basepath: dir,
+			RunList:  []string{},
+			Vars:     map[string]interface{}{},
+		}
+
+		var config interface{}
+		if ext == ".lua" {
+			v, err := evalRoleFile(file)
+			if err != nil {
+				return nil, err
+			}
+			config = v
+		} else {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+
+			m, err := parseVariables(b, "yaml")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			config = m
+		}
+
+		if err := role.setConfig(config); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+
+		return role, nil
+	}
+
+	return nil, fmt.Errorf("role '%s' is not found in '%s'", name, filepath.Join(dir, RolesDir))
+}
+
+// evalRoleFile runs the lua role file that returns a table like '{run_list = {...}, vars = {...}}'.
+// The role files are evaluated before Init and the variables are loaded, so it runs in a separate LState
+// that doesn't have the functions of cofu and 'var'.
+func evalRoleFile(file string) (interface{}, error) {
+	L := lua.NewState()
+	defer L.Close()
+
+	fn, err := L.LoadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		return nil, err
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	tb, ok := ret.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("%s: the role file must return a table", file)
+	}
+
+	return toGoValue(tb), nil
+}
+
+func (role *Role) setConfig(config interface{}) error {
+	m, ok := config.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("the role must be a table that has 'run_list' and 'vars'")
+	}
+
+	for key, value := range m {
+		if value == nil {
+			continue
+		}
+		if empty, ok := value.(map[string]interface{}); ok && len(empty) == 0 {
+			// an empty lua table is not distinguished from an empty array.
+			continue
+		}
+
+		switch key {
+		case "run_list":
+			list, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("'run_list' must be an array of recipes")
+			}
+
+			for _, item := range list {
+				recipe, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("'run_list' must be an array of recipes")
+				}
+				role.RunList = append(role.RunList, recipe)
+			}
+		case "vars":
+			vars, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("'vars' must be a table")
+			}
+			role.Vars = vars
+		default:
+			return fmt.Errorf("unknown key '%s'", key)
+		}
+	}
+
+	return nil
+}
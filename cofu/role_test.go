@@ -0,0 +1,83 @@
+package cofu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppLoadRoles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_role_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"roles/web.yml":      "run_list:\n  - recipes/base\n  - recipes/web.lua\nvars:\n  nginx:\n    port: 80\n",
+		"roles/worker.lua":   `return {run_list = {"recipes/base.lua", "recipes/worker.lua"}, vars = {queue = "jobs"}}`,
+		"recipes/base.lua":   `test "base" {}`,
+		"recipes/web.lua":    `test ("web" .. var.nginx.port) {}`,
+		"recipes/worker.lua": `test ("worker_" .. var.queue) {}`,
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+
+	roles, err := app.LoadRoles(dir, []string{"web", "worker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRunList(roles); err != nil {
+		t.Fatal(err)
+	}
+
+	descs := []string{}
+	for _, r := range app.Resources {
+		descs = append(descs, r.Desc()+"@"+r.Role)
+	}
+
+	expected := []string{"test[base]@web", "test[web80]@web", "test[worker_jobs]@worker"}
+	if strings.Join(descs, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected resources %v", descs)
+	}
+
+	// the main recipe that is also in the run-list is not loaded again.
+	if err := app.LoadRecipeFile(filepath.Join(dir, "recipes/web.lua")); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Resources) != 3 {
+		t.Errorf("the recipe in the run-list must not be loaded again: %d", len(app.Resources))
+	}
+
+	if _, err := app.LoadRoles(dir, []string{"unknown"}); err == nil {
+		t.Error("unknown role must be an error")
+	}
+
+	// the lua role files don't have the functions of cofu.
+	if err := ioutil.WriteFile(filepath.Join(dir, "roles/db.lua"), []byte(`return {vars = {port = var.port}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.LoadRoles(dir, []string{"db"}); err == nil {
+		t.Error("the role file that uses var must be an error")
+	}
+}
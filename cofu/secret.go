@@ -0,0 +1,216 @@
+package cofu
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/kohkimakimoto/cofu/infra/util"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SecretsHeader is the first line of the encrypted secret variables file.
+const SecretsHeader = "$COFU_SECRETS;1;AES256-GCM"
+
+// SecretsKeyEnv is the environment variable that has the key of the secret variables files.
+const SecretsKeyEnv = "COFU_SECRETS_KEY"
+
+// SecretMask is the string that is shown instead of the secret values.
+const SecretMask = "********"
+
+// secretsKeySize is the size of the key for AES-256.
+const secretsKeySize = 32
+
+// minSecretLength is the minimum length of the secret values to be masked.
+// The shorter values are not masked to avoid masking unrelated outputs.
+const minSecretLength = 4
+
+// LoadSecretsKey loads the key of the secret variables files from the keyFile.
+// If the keyFile is empty, it uses the SecretsKeyEnv environment variable.
+// The key must be 32 random bytes encoded in hex or base64.
+func LoadSecretsKey(keyFile string) ([]byte, error) {
+	var material string
+	if keyFile != "" {
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		material = strings.TrimSpace(string(b))
+	} else {
+		material = strings.TrimSpace(os.Getenv(SecretsKeyEnv))
+	}
+
+	if material == "" {
+		return nil, fmt.Errorf("the secrets key is not specified. Use -secrets-key-file option or %s environment variable.", SecretsKeyEnv)
+	}
+
+	return decodeSecretsKey(material)
+}
+
+// decodeSecretsKey decodes the key that is encoded in hex or base64.
+// It does not accept passphrases because they do not have enough entropy for AES-256.
+func decodeSecretsKey(material string) ([]byte, error) {
+	if key, err := hex.DecodeString(material); err == nil && len(key) == secretsKeySize {
+		return key, nil
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(material); err == nil && len(key) == secretsKeySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("the secrets key must be %d random bytes encoded in hex or base64. You can generate it by 'openssl rand -base64 %d'.", secretsKeySize, secretsKeySize)
+}
+
+// EncryptSecrets encrypts the plaintext with AES-GCM.
+func EncryptSecrets(plaintext, key []byte) ([]byte, error) {
+	gcm, err := newSecretsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil))
+
+	var b bytes.Buffer
+	b.WriteString(SecretsHeader + "\n")
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded + "\n")
+
+	return b.Bytes(), nil
+}
+
+// DecryptSecrets decrypts the data that is encrypted by EncryptSecrets.
+func DecryptSecrets(data, key []byte) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if strings.TrimSpace(lines[0]) != SecretsHeader {
+		return nil, fmt.Errorf("not an encrypted secrets file")
+	}
+
+	encrypted, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:], ""))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newSecretsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encrypted) < gcm.NonceSize() {
+		return nil, fmt.Errorf("the encrypted secrets is broken")
+	}
+
+	plaintext, err := gcm.Open(nil, encrypted[:gcm.NonceSize()], encrypted[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the secrets. The key may be wrong.")
+	}
+
+	return plaintext, nil
+}
+
+func newSecretsCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// LoadSecretVariableFile decrypts the secret variables file and merges it into the variables.
+// The content is YAML (or JSON). The values are masked in the outputs.
+func (app *App) LoadSecretVariableFile(file string, key []byte) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := DecryptSecrets(b, key)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	m, err := parseVariables(plaintext, "yaml")
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	app.addSecrets("", normalizeVariable(m))
+
+	return app.LoadVariableFromMap(m)
+}
+
+// addSecrets registers the string values in the v as secrets. The name is the path of the v in the variables.
+func (app *App) addSecrets(name string, v interface{}) {
+	switch converted := v.(type) {
+	case map[string]interface{}:
+		for key, item := range converted {
+			if name != "" {
+				key = name + "." + key
+			}
+			app.addSecrets(key, item)
+		}
+	case []interface{}:
+		for i, item := range converted {
+			app.addSecrets(fmt.Sprintf("%s[%d]", name, i+1), item)
+		}
+	case string, float64:
+		s := fmt.Sprint(converted)
+		if len(s) < minSecretLength {
+			app.Logger.Warnf("Secret variable '%s' is not masked in the outputs because it is shorter than %d characters.", name, minSecretLength)
+			return
+		}
+		app.secrets = append(app.secrets, s)
+
+		// the value is embedded in commands by shell_escape.
+		// the outer quotes are trimmed to mask it in the quoted commands too.
+		if escaped := strings.TrimSuffix(strings.TrimPrefix(util.ShellEscape(s), "'"), "'"); escaped != s {
+			app.secrets = append(app.secrets, escaped)
+		}
+
+		// replace the longer ones first not to leave a part of them.
+		sort.Slice(app.secrets, func(i, j int) bool {
+			return len(app.secrets[i]) > len(app.secrets[j])
+		})
+	}
+}
+
+// MaskSecrets replaces the secret values in the s with SecretMask.
+func (app *App) MaskSecrets(s string) string {
+	if app.Parent != nil {
+		return app.Parent.MaskSecrets(s)
+	}
+
+	for _, secret := range app.secrets {
+		s = strings.Replace(s, secret, SecretMask, -1)
+	}
+
+	return s
+}
+
+// maskSecretValue masks the value for showing it.
+func (app *App) maskSecretValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return app.MaskSecrets(s)
+	}
+
+	if masked := app.MaskSecrets(fmt.Sprint(v)); masked != fmt.Sprint(v) {
+		return masked
+	}
+
+	return v
+}
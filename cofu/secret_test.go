@@ -0,0 +1,150 @@
+package cofu
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptSecrets(t *testing.T) {
+	key := make([]byte, 32)
+
+	encrypted, err := EncryptSecrets([]byte("password: hunter2\n"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := DecryptSecrets(encrypted, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "password: hunter2\n" {
+		t.Errorf("unexpected plaintext %q", plaintext)
+	}
+
+	wrongKey := make([]byte, 32)
+	wrongKey[0] = 1
+	if _, err := DecryptSecrets(encrypted, wrongKey); err == nil {
+		t.Error("decrypting with a wrong key must be an error")
+	}
+}
+
+func TestLoadSecretsKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_secret_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]bool{
+		strings.Repeat("ab", 32):                            true,
+		base64.StdEncoding.EncodeToString(make([]byte, 32)): true,
+		"correct horse battery staple":                      false,
+		strings.Repeat("ab", 16):                            false,
+	}
+
+	for material, valid := range cases {
+		keyFile := filepath.Join(dir, "key")
+		if err := ioutil.WriteFile(keyFile, []byte(material+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := LoadSecretsKey(keyFile)
+		if valid && (err != nil || len(key) != 32) {
+			t.Errorf("%s: unexpected result %v, %v", material, key, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%s: the key must be rejected", material)
+		}
+	}
+}
+
+func TestAppLoadSecretVariableFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_secret_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := make([]byte, 32)
+	encrypted, err := EncryptSecrets([]byte("db:\n  password: hunter2\n  port: 5432\n  quoted: it's secret\n  pin: 123\n"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secretFile := filepath.Join(dir, "secrets.yml")
+	if err := ioutil.WriteFile(secretFile, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp()
+	defer app.Close()
+	logs := new(bytes.Buffer)
+	app.Logger.SetOutput(logs)
+	app.ResourceTypes = []*ResourceType{
+		{
+			Name: "test",
+			Attributes: []Attribute{
+				&StringSliceAttribute{
+					Name:     "action",
+					Default:  []string{"run"},
+					Required: true,
+				},
+				&StringAttribute{
+					Name: "content",
+				},
+			},
+			SetCurrentAttributesFunc: func(r *Resource) error {
+				r.CurrentAttributes["content"] = "old"
+				return nil
+			},
+			Actions: map[string]ResourceAction{
+				"run": func(r *Resource) error {
+					return nil
+				},
+			},
+		},
+	}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadSecretVariableFile(secretFile, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+assert(var.db.password == "hunter2")
+test "a" {
+    content = "password=" .. var.db.password,
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Run(true); err != nil {
+		t.Fatal(err)
+	}
+
+	d := app.Report.Resources[0].Differences[0]
+	if d.Current != "old" || d.Desired != "password=********" {
+		t.Errorf("unexpected difference %+v", d)
+	}
+
+	if masked := app.MaskSecrets("port 5432"); masked != "port ********" {
+		t.Errorf("unexpected masked string %s", masked)
+	}
+
+	// the value escaped by shell_escape is also masked.
+	if masked := app.MaskSecrets("echo 'it'\"'\"'s secret'"); masked != "echo '********'" {
+		t.Errorf("unexpected masked string %s", masked)
+	}
+
+	if !strings.Contains(logs.String(), "Secret variable 'db.pin' is not masked") {
+		t.Errorf("the short secret must be warned: %s", logs.String())
+	}
+}
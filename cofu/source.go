@@ -0,0 +1,253 @@
+package cofu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// SourceFetcher fetches the go-getter style source into the dst directory.
+type SourceFetcher func(src, dst string) error
+
+// DefaultSourceRecipe is the recipe file that is loaded if the source doesn't have a subdirectory.
+const DefaultSourceRecipe = "recipe.lua"
+
+// ErrSourceNotFetched is returned by ResolveRecipeSource in validating mode if the source is not cached.
+var ErrSourceNotFetched = errors.New("remote sources are not fetched in validating mode")
+
+var archiveExtensions = []string{
+	".zip",
+	".tar",
+	".tar.gz",
+	".tgz",
+	".tar.bz2",
+	".tbz2",
+	".tar.xz",
+	".txz",
+}
+
+// SandboxFetcher fetches the source by running 'cofu -fetch' in another process.
+func SandboxFetcher(src, dst string) error {
+	out, err := exec.Command(BinPath, "-fetch", src, dst).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// fetch fetches the source by the Fetcher of the app.
+func (app *App) fetch(src, dst string) error {
+	if app.Fetcher == nil {
+		return errors.New("the remote sources can not be fetched because App.Fetcher is not set")
+	}
+
+	return app.Fetcher(src, dst)
+}
+
+// IsRemoteSource returns true if the recipe path is a go-getter style source
+// like 'git::https://example.com/recipes.git//web.lua', 'file:///srv/recipes//web.lua' or 'recipes.tar.gz//web.lua'.
+func IsRemoteSource(path string) bool {
+	if strings.Contains(path, "::") || strings.Contains(path, "://") {
+		return true
+	}
+
+	src, _ := splitSourceSubdir(path)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(src, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResolveRecipeSource fetches the remote source into the cache directory and returns the path of the recipe file in it.
+// The relative local archive is resolved from the current directory.
+func (app *App) ResolveRecipeSource(source, current string) (string, error) {
+	if app.Parent != nil {
+		// the fetched sources are shared in the root app.
+		return app.Parent.ResolveRecipeSource(source, current)
+	}
+
+	src, subdir := splitSourceSubdir(source)
+	if !strings.Contains(src, "::") && !strings.Contains(src, "://") && !filepath.IsAbs(src) {
+		src = filepath.Join(current, src)
+	}
+
+	cacheDir, err := app.sourceCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(src))
+	dir := filepath.Join(cacheDir, hex.EncodeToString(sum[:]))
+
+	if app.Validating {
+		// validating must not have side effects. only the cached sources are used.
+		if _, err := os.Stat(dir); err != nil {
+			return "", ErrSourceNotFetched
+		}
+	} else if app.RefreshSources && !app.fetchedSources[dir] {
+		if err := os.RemoveAll(dir); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		app.Logger.Infof("Fetching recipe source '%s'", app.MaskSecrets(src))
+
+		// fetch into a temporary directory not to leave a partially fetched source in the cache.
+		tmpdir := dir + ".fetching"
+		if err := os.RemoveAll(tmpdir); err != nil {
+			return "", err
+		}
+
+		if err := app.fetch(src, tmpdir); err != nil {
+			os.RemoveAll(tmpdir)
+			return "", fmt.Errorf("failed to fetch '%s': %v", app.MaskSecrets(src), err)
+		}
+
+		if err := os.Rename(tmpdir, dir); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	} else {
+		app.Logger.Debugf("Using the cached recipe source '%s' in '%s'", app.MaskSecrets(src), dir)
+	}
+	app.fetchedSources[dir] = true
+
+	if subdir == "" {
+		subdir = DefaultSourceRecipe
+	}
+	if !strings.HasSuffix(subdir, ".lua") {
+		subdir += ".lua"
+	}
+
+	path := filepath.Join(dir, subdir)
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' is out of the source '%s'", subdir, app.MaskSecrets(src))
+	}
+
+	return path, nil
+}
+
+// sourceCacheDir returns the directory to cache the fetched sources.
+// It and its parents must be owned by the user (or root) and must not be writable by other users,
+// because the recipes in it are executed.
+func (app *App) sourceCacheDir() (string, error) {
+	dir := app.SourceCacheDir
+	if dir == "" {
+		d, err := defaultSourceCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = d
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	if err := checkPrivateDir(dir, os.Geteuid()); err != nil {
+		return "", fmt.Errorf("the source cache directory '%s' is not safe: %v", dir, err)
+	}
+
+	return dir, nil
+}
+
+// defaultSourceCacheDir returns 'cofu/sources' in $XDG_CACHE_HOME or '~/.cache'.
+// It isn't in the shared Tmpdir that other users can write into.
+func defaultSourceCacheDir() (string, error) {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "cofu", "sources"), nil
+	}
+
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".cache", "cofu", "sources"), nil
+	}
+
+	return "", fmt.Errorf("could not decide the source cache directory. Use -source-cache-dir option.")
+}
+
+// checkPrivateDir checks that the dir is owned by the uid and that the dir and its parents can't be modified by other users.
+// The parents may be owned by root, and may be writable by others if they have the sticky bit like '/tmp'.
+func checkPrivateDir(dir string, uid int) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	for path := dir; ; path = filepath.Dir(path) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("could not get the owner of '%s'", path)
+		}
+
+		owner := int(st.Uid)
+		if path == dir {
+			if owner != uid {
+				return fmt.Errorf("'%s' is owned by uid %d", path, owner)
+			}
+			if fi.Mode().Perm()&0022 != 0 {
+				return fmt.Errorf("'%s' is writable by other users", path)
+			}
+		} else {
+			if owner != uid && owner != 0 {
+				return fmt.Errorf("'%s' is owned by uid %d", path, owner)
+			}
+			if fi.Mode().Perm()&0022 != 0 && fi.Mode()&os.ModeSticky == 0 {
+				return fmt.Errorf("'%s' is writable by other users", path)
+			}
+		}
+
+		if parent := filepath.Dir(path); parent == path {
+			break
+		}
+	}
+
+	return nil
+}
+
+// splitSourceSubdir splits the source into the source and the subdirectory by '//'.
+// The query string is kept in the source. It is the same as go-getter's SourceDirSubdir.
+func splitSourceSubdir(src string) (string, string) {
+	// avoid marking the scheme as the subdirectory.
+	offset := 0
+	if idx := strings.Index(src, "://"); idx > -1 {
+		offset = idx + 3
+	}
+
+	idx := strings.Index(src[offset:], "//")
+	if idx == -1 {
+		return src, ""
+	}
+	idx += offset
+
+	subdir := src[idx+2:]
+	src = src[:idx]
+
+	if idx := strings.Index(subdir, "?"); idx > -1 {
+		src += subdir[idx:]
+		subdir = subdir[:idx]
+	}
+
+	return src, subdir
+}
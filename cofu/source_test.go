@@ -0,0 +1,156 @@
+package cofu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitSourceSubdir(t *testing.T) {
+	cases := []struct {
+		source string
+		src    string
+		subdir string
+	}{
+		{"git::https://example.com/recipes.git//web.lua?ref=v1", "git::https://example.com/recipes.git?ref=v1", "web.lua"},
+		{"file:///srv/recipes//roles/web", "file:///srv/recipes", "roles/web"},
+		{"recipes.tar.gz", "recipes.tar.gz", ""},
+	}
+
+	for _, c := range cases {
+		src, subdir := splitSourceSubdir(c.source)
+		if src != c.src || subdir != c.subdir {
+			t.Errorf("%s: unexpected src '%s' and subdir '%s'", c.source, src, subdir)
+		}
+	}
+
+	if IsRemoteSource("roles/web.lua") || !IsRemoteSource("shared.tar.gz//web") || !IsRemoteSource("git::file:///srv/recipes.git") {
+		t.Error("unexpected IsRemoteSource result")
+	}
+}
+
+func TestAppIncludeRemoteRecipe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_source_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the fetched source has a recipe that includes another recipe by a relative path.
+	files := map[string]string{
+		"web.lua":    `include_recipe "nginx.lua"`,
+		"nginx.lua":  `test "nginx" {}`,
+		"recipe.lua": `test "default" {}`,
+	}
+
+	fetched := []string{}
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.SourceCacheDir = filepath.Join(dir, "cache")
+	app.Fetcher = func(src, dst string) error {
+		fetched = append(fetched, src)
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dst, name), []byte(content), 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+include_recipe "git::file:///srv/recipes.git//web?ref=v1"
+include_recipe "git::file:///srv/recipes.git?ref=v1"
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fetched) != 1 || fetched[0] != "git::file:///srv/recipes.git?ref=v1" {
+		t.Errorf("the source must be fetched once but got %v", fetched)
+	}
+
+	if len(app.Resources) != 2 || app.Resources[0].Desc() != "test[nginx]" || app.Resources[1].Desc() != "test[default]" {
+		t.Errorf("unexpected resources %v", app.Resources)
+	}
+
+	if err := app.LoadRecipe(`include_recipe "git::file:///srv/recipes.git//../web"`); err == nil {
+		t.Error("the recipe out of the source must be an error")
+	}
+}
+
+func TestAppIncludeRemoteRecipeWithoutFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_source_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app := NewApp()
+	defer app.Close()
+	app.SourceCacheDir = filepath.Join(dir, "cache")
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.LoadRecipe(`include_recipe "git::file:///srv/recipes.git//web"`)
+	if err == nil || !strings.Contains(err.Error(), "App.Fetcher is not set") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestSourceCacheDirMustBePrivate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_source_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cacheDir := filepath.Join(dir, "cache")
+	if err := os.Mkdir(cacheDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkPrivateDir(cacheDir, os.Geteuid()); err != nil {
+		t.Errorf("the private cache directory must be accepted: %v", err)
+	}
+
+	// the cache directory that is owned by another user.
+	if err := checkPrivateDir(cacheDir, os.Geteuid()+1); err == nil {
+		t.Error("the cache directory owned by another user must be rejected")
+	}
+
+	// the cache directory in a directory that other users can write into.
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPrivateDir(cacheDir, os.Geteuid()); err == nil {
+		t.Error("the cache directory in a world-writable directory must be rejected")
+	}
+
+	if os.Geteuid() == 0 {
+		// a cache that another user has created in advance.
+		if err := os.Chmod(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(cacheDir, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+
+		app := NewApp()
+		defer app.Close()
+		app.SourceCacheDir = cacheDir
+		if _, err := app.sourceCacheDir(); err == nil {
+			t.Error("the cache directory owned by another user must be rejected")
+		}
+	}
+}
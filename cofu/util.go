@@ -52,6 +52,18 @@ func toString(v lua.LValue) (string, bool) {
 	}
 }
 
+func containsAnyString(ss []string, targets []string) bool {
+	for _, s := range ss {
+		for _, t := range targets {
+			if s == t {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // CurrentDir returns a directory path that includes lua source file which is executed now.
 func CurrentDir(L *lua.LState) string {
 	// same: debug.getinfo(2,'S').source
@@ -168,3 +180,12 @@ func CopyDir(src string, dst string) (err error) {
 
 	return
 }
+
+func countTableEntries(tb *lua.LTable) int {
+	n := 0
+	tb.ForEach(func(_, _ lua.LValue) {
+		n++
+	})
+
+	return n
+}
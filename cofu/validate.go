@@ -0,0 +1,63 @@
+package cofu
+
+import (
+	"fmt"
+)
+
+// ValidationError is an error in a recipe. Location is like 'recipe.lua:3:'.
+type ValidationError struct {
+	Location string
+	Err      error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Location == "" {
+		return e.Err.Error()
+	}
+
+	return e.Location + " " + e.Err.Error()
+}
+
+// Validate checks the loaded resources without converging them.
+// It returns all errors found while loading recipes (if Validating is true) and checking the resources.
+func (app *App) Validate() []error {
+	errs := append([]error{}, app.validationErrors...)
+	errs = append(errs, app.prepare()...)
+
+	if graph, err := NewResourceGraph(app.Resources); err != nil {
+		errs = append(errs, err)
+	} else if _, err := graph.Sort(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, r := range app.Resources {
+		if r.ResourceType.ValidateFunc == nil {
+			continue
+		}
+
+		if err := r.ResourceType.ValidateFunc(r); err != nil {
+			errs = append(errs, r.validationErrorf("resource '%s': %v", r.Desc(), err))
+		}
+	}
+
+	return errs
+}
+
+// addValidationError records the error found while loading recipes in validating mode.
+func (app *App) addValidationError(location string, err error) {
+	app.validationErrors = append(app.validationErrors, &ValidationError{
+		Location: location,
+		Err:      err,
+	})
+}
+
+func (r *Resource) validationError(err error) error {
+	return &ValidationError{
+		Location: r.Location,
+		Err:      err,
+	}
+}
+
+func (r *Resource) validationErrorf(format string, args ...interface{}) error {
+	return r.validationError(fmt.Errorf(format, args...))
+}
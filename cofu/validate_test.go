@@ -0,0 +1,120 @@
+package cofu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kohkimakimoto/cofu/infra"
+)
+
+func TestAppValidate(t *testing.T) {
+	executed := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.Infra = infra.NewNoExec()
+	app.Validating = true
+	app.ResourceTypes = []*ResourceType{newTestRecordingResourceType(&executed)}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+include_recipe "/path/to/missing"
+test "a" {
+    action = "unknown",
+    unknown = true,
+}
+test "b" {
+    notifies = {"run", "test[c]"},
+    depends_on = "test[d]",
+    only_if = run_command("echo ok"):success() and "true" or "false",
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := app.Validate()
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	expected := []string{
+		"<string>:2: include_recipe: '/path/to/missing.lua' is not found.",
+		"<string>:3: test[a]: 'action' attribute must be one of 'nothing', 'run' but got 'unknown'.",
+		"<string>:3: test[a]: Invalid attribute name 'unknown'.",
+		"<string>:7: resource 'test[b]': notification target 'test[c]' is not found.",
+		"<string>:7: resource 'test[b]': depends on 'test[d]' but it is not found.",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected errors\n%s", strings.Join(messages, "\n"))
+	}
+
+	// commands are not executed.
+	if app.Resources[1].GetStringAttribute("only_if") != "false" {
+		t.Errorf("run_command must fail in no-exec mode")
+	}
+
+	if len(executed) != 0 {
+		t.Errorf("validate must not converge resources")
+	}
+}
+
+func TestAppValidateDoesNotFetchSourcesOrRunPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_validate_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the plugin leaves a file if it is run.
+	plugin := "#!/bin/sh\ntouch \"$(dirname \"$0\")/executed\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, PluginPrefix+"kv"), []byte(plugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched := []string{}
+
+	app := NewApp()
+	defer app.Close()
+	app.Infra = infra.NewNoExec()
+	app.Validating = true
+	app.PluginPaths = []string{dir}
+	app.SourceCacheDir = filepath.Join(dir, "cache")
+	app.Fetcher = func(src, dst string) error {
+		fetched = append(fetched, src)
+		return nil
+	}
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+include_recipe "git::https://example.com/recipes.git//web.lua"
+kv "a" {
+    value = "new",
+    action = "set",
+}
+kv "b" {
+    notifies = {"set", "kv[a]"},
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := app.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	if len(fetched) != 0 {
+		t.Errorf("validate must not fetch the sources but fetched %v", fetched)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "executed")); err == nil {
+		t.Error("validate must not run the plugins")
+	}
+}
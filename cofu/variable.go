@@ -0,0 +1,224 @@
+package cofu
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VariableEnvPrefix is a prefix of the environment variables that are loaded into 'var'.
+// A double underscore in the name separates the keys of the nested tables.
+// ex) COFU_VAR_nginx__port=8080 -> var.nginx.port
+const VariableEnvPrefix = "COFU_VAR_"
+
+// DefaultVariableFiles are the file names of the default variables in the recipe directory.
+var DefaultVariableFiles = []string{
+	"default_vars.json",
+	"default_vars.yml",
+	"default_vars.yaml",
+	"default_vars.toml",
+}
+
+// Variables returns the merged variables that are exposed as 'var' in the recipes.
+func (app *App) Variables() map[string]interface{} {
+	return app.variable
+}
+
+func (app *App) LoadVariableFromJSON(v string) error {
+	m, err := parseVariables([]byte(v), "json")
+	if err != nil {
+		return err
+	}
+
+	return app.LoadVariableFromMap(m)
+}
+
+func (app *App) LoadVariableFromJSONFile(jsonFile string) error {
+	b, err := ioutil.ReadFile(jsonFile)
+	if err != nil {
+		return err
+	}
+
+	m, err := parseVariables(b, "json")
+	if err != nil {
+		return fmt.Errorf("%s: %v", jsonFile, err)
+	}
+
+	return app.LoadVariableFromMap(m)
+}
+
+// LoadVariableFromFile loads the variables from a JSON, YAML or TOML file.
+// The format is detected by the file extension. A file that has another extension or no extension is loaded as JSON.
+func (app *App) LoadVariableFromFile(file string) error {
+	format := "json"
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		format = "yaml"
+	case ".toml":
+		format = "toml"
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	m, err := parseVariables(b, format)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	return app.LoadVariableFromMap(m)
+}
+
+// LoadDefaultVariables loads the first found file of DefaultVariableFiles in the dir.
+func (app *App) LoadDefaultVariables(dir string) error {
+	for _, name := range DefaultVariableFiles {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+
+		app.Logger.Debugf("Loading default variables from '%s'", file)
+		return app.LoadVariableFromFile(file)
+	}
+
+	return nil
+}
+
+// LoadVariableFromEnv loads the variables from the environment variables that have VariableEnvPrefix.
+// The values are decoded as JSON if they are valid JSON, otherwise they are used as strings.
+func (app *App) LoadVariableFromEnv(environ []string) error {
+	m := map[string]interface{}{}
+	for _, env := range environ {
+		if !strings.HasPrefix(env, VariableEnvPrefix) {
+			continue
+		}
+
+		kv := strings.SplitN(strings.TrimPrefix(env, VariableEnvPrefix), "=", 2)
+		if kv[0] == "" || len(kv) != 2 {
+			continue
+		}
+
+		var value interface{}
+		if err := json.Unmarshal([]byte(kv[1]), &value); err != nil {
+			value = kv[1]
+		}
+
+		keys := strings.Split(kv[0], "__")
+		for i := len(keys) - 1; i > 0; i-- {
+			value = map[string]interface{}{keys[i]: value}
+		}
+		mergeVariables(m, map[string]interface{}{keys[0]: value})
+	}
+
+	return app.LoadVariableFromMap(m)
+}
+
+// LoadVariableFromMap merges the m into the variables deeply.
+// The nested tables are merged and the other values are overwritten.
+func (app *App) LoadVariableFromMap(m map[string]interface{}) error {
+	mergeVariables(app.variable, normalizeVariable(m).(map[string]interface{}))
+
+	L := app.LState
+	L.SetGlobal("var", toLValue(L, app.variable))
+
+	return nil
+}
+
+func parseVariables(b []byte, format string) (map[string]interface{}, error) {
+	var m map[string]interface{}
+
+	switch format {
+	case "json":
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+	case "yaml":
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return map[string]interface{}{}, nil
+		}
+
+		nm, ok := normalizeVariable(v).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("variables must be a mapping")
+		}
+		m = nm
+	case "toml":
+		if _, err := toml.Decode(string(b), &m); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported variable format '%s'", format)
+	}
+
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+
+	return m, nil
+}
+
+func mergeVariables(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, ok1 := v.(map[string]interface{})
+		dstMap, ok2 := dst[k].(map[string]interface{})
+		if ok1 && ok2 {
+			mergeVariables(dstMap, srcMap)
+			continue
+		}
+
+		dst[k] = v
+	}
+}
+
+// normalizeVariable converts the values decoded from YAML and TOML into the types that are decoded from JSON.
+func normalizeVariable(v interface{}) interface{} {
+	switch converted := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(converted))
+		for key, item := range converted {
+			m[key] = normalizeVariable(item)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(converted))
+		for key, item := range converted {
+			m[fmt.Sprint(key)] = normalizeVariable(item)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, 0, len(converted))
+		for _, item := range converted {
+			arr = append(arr, normalizeVariable(item))
+		}
+		return arr
+	case []map[string]interface{}:
+		arr := make([]interface{}, 0, len(converted))
+		for _, item := range converted {
+			arr = append(arr, normalizeVariable(item))
+		}
+		return arr
+	case int:
+		return float64(converted)
+	case int64:
+		return float64(converted)
+	case uint64:
+		return float64(converted)
+	case float32:
+		return float64(converted)
+	case time.Time:
+		return converted.Format(time.RFC3339)
+	}
+	return v
+}
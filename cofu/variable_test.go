@@ -0,0 +1,104 @@
+package cofu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppLoadVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_variable_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"default_vars.yml": "nginx:\n  port: 80\n  workers: 2\nusers: [a, b]\n",
+		"prod.toml":        "[nginx]\nport = 8080\n",
+		"extra.json":       `{"nginx": {"ssl": true}}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := NewApp()
+	defer app.Close()
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadDefaultVariables(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"prod.toml", "extra.json"} {
+		if err := app.LoadVariableFromFile(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.LoadVariableFromJSON(`{"nginx": {"workers": 4}}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.LoadVariableFromEnv([]string{"COFU_VAR_nginx__host=example.com", "COFU_VAR_debug=true", "HOME=/root"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.LoadRecipe(`
+assert(var.nginx.port == 8080)
+assert(var.nginx.workers == 4)
+assert(var.nginx.ssl == true)
+assert(var.nginx.host == "example.com")
+assert(var.users[2] == "b")
+assert(var.debug == true)
+assert(var.HOME == nil)
+assert(var.GOOS ~= nil)
+`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAppLoadVariableFromFileAsJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cofu_variable_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the files that have an unknown extension or no extension are JSON.
+	files := map[string]string{
+		"vars":      `{"a": 1}`,
+		"vars.conf": `{"b": 2}`,
+		"vars.ini":  "b = 2",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := NewApp()
+	defer app.Close()
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"vars", "vars.conf"} {
+		if err := app.LoadVariableFromFile(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := app.LoadRecipe(`
+assert(var.a == 1)
+assert(var.b == 2)
+`); err != nil {
+		t.Error(err)
+	}
+
+	if err := app.LoadVariableFromFile(filepath.Join(dir, "vars.ini")); err == nil {
+		t.Error("a file that is not JSON must be an error")
+	}
+}
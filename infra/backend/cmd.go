@@ -2,17 +2,25 @@ package backend
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/kohkimakimoto/cofu/infra/util"
 	"io"
 	"os"
 	"os/exec"
 	"runtime"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+// ErrNoExec is an error of the commands that are not executed because of NoExec.
+var ErrNoExec = errors.New("commands are not executed in no-exec mode")
+
 type Cmd struct {
 	Shell string
+	// NoExec makes all commands fail without executing them.
+	NoExec bool
 }
 
 func NewCmd(shell string) *Cmd {
@@ -37,6 +45,17 @@ func (c *Cmd) BuildCommand(command string, option *CommandOption) string {
 }
 
 func (c *Cmd) RunCommand(command string) *CommandResult {
+	return c.RunCommandWithOption(command, nil)
+}
+
+func (c *Cmd) RunCommandWithOption(command string, option *CommandOption) *CommandResult {
+	if c.NoExec {
+		return &CommandResult{
+			ExitStatus: 1,
+			Err:        ErrNoExec,
+		}
+	}
+
 	var cmd *exec.Cmd
 
 	if runtime.GOOS == "windows" {
@@ -53,8 +72,35 @@ func (c *Cmd) RunCommand(command string) *CommandResult {
 	cmd.Stderr = io.MultiWriter(&stderr, &combined)
 	cmd.Stdin = os.Stdin
 
+	var timeout time.Duration
+	if option != nil {
+		if option.Dir != "" {
+			cmd.Dir = option.Dir
+		}
+		timeout = option.Timeout
+	}
+
+	if timeout > 0 {
+		// create a new process group to kill the all processes that are started by the shell.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+
 	var exitStatus int
-	err := cmd.Run()
+	var timedOut int32
+	err := cmd.Start()
+	if err == nil {
+		if timeout > 0 {
+			timer := time.AfterFunc(timeout, func() {
+				atomic.StoreInt32(&timedOut, 1)
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			})
+			err = cmd.Wait()
+			timer.Stop()
+		} else {
+			err = cmd.Wait()
+		}
+	}
+
 	if err != nil {
 		if e2, ok := err.(*exec.ExitError); ok {
 			if s, ok := e2.Sys().(syscall.WaitStatus); ok {
@@ -67,13 +113,20 @@ func (c *Cmd) RunCommand(command string) *CommandResult {
 		exitStatus = 0
 	}
 
-	return &CommandResult{
+	result := &CommandResult{
 		Stdout:     stdout,
 		Stderr:     stderr,
 		Combined:   combined,
 		Err:        err,
 		ExitStatus: exitStatus,
 	}
+
+	if atomic.LoadInt32(&timedOut) == 1 {
+		result.TimedOut = true
+		result.Err = fmt.Errorf("command timed out after %v and was killed", timeout)
+	}
+
+	return result
 }
 
 type CommandResult struct {
@@ -82,6 +135,8 @@ type CommandResult struct {
 	Combined   bytes.Buffer
 	ExitStatus int
 	Err        error
+	// TimedOut is true if the command was killed because of the timeout.
+	TimedOut bool
 }
 
 func (r *CommandResult) Success() bool {
@@ -95,4 +150,9 @@ func (r *CommandResult) Failure() bool {
 type CommandOption struct {
 	User string
 	Cwd  string
+	// Dir is a working directory of the process that runs the command.
+	// Cwd is changed by the built command, but Dir is set to the process directly.
+	Dir string
+	// Timeout is a duration after which the command is killed. Zero means no timeout.
+	Timeout time.Duration
 }
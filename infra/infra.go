@@ -21,6 +21,18 @@ func New() *Infra {
 	return i
 }
 
+// NewNoExec returns an Infra that never executes commands.
+// All commands fail, so the os is detected as unknown.
+func NewNoExec() *Infra {
+	cmd := backend.NewCmd("/bin/sh")
+	cmd.NoExec = true
+
+	return &Infra{
+		cmd:       cmd,
+		detectors: detector.DefaultDetectors,
+	}
+}
+
 func (i *Infra) Command() command.CommandFactory {
 	if i.commandFactory == nil {
 		for _, detector := range i.detectors {
@@ -40,6 +52,10 @@ func (i *Infra) RunCommand(command string) *backend.CommandResult {
 	return i.cmd.RunCommand(command)
 }
 
+func (i *Infra) RunCommandWithOption(command string, option *backend.CommandOption) *backend.CommandResult {
+	return i.cmd.RunCommandWithOption(command, option)
+}
+
 func (i *Infra) BuildCommand(command string, option *backend.CommandOption) string {
 	return i.cmd.BuildCommand(command, option)
 }
@@ -20,7 +20,8 @@ var Directory = &cofu.ResourceType{
 			Required:    true,
 		},
 		&cofu.StringAttribute{
-			Name: "mode",
+			Name:    "mode",
+			Pattern: fileModePattern,
 		},
 		&cofu.StringAttribute{
 			Name: "owner",
@@ -40,10 +40,14 @@ func executeSetCurrentAttributes(r *cofu.Resource) error {
 }
 
 func executeRunAction(r *cofu.Resource) error {
-	logger := r.App.Logger
+	logger := r.Logger()
 	ret := r.MustRunCommand(r.GetStringAttribute("command"))
 
-	logger.Debugf("%s\n", ret.Combined.String())
+	if r.IsSensitive() {
+		logger.Debugf("(the output is hidden because the resource is sensitive)\n")
+	} else {
+		logger.Debugf("%s\n", r.Redact(ret.Combined.String()))
+	}
 
 	return nil
 }
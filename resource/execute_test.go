@@ -3,7 +3,9 @@ package resource
 import (
 	"bytes"
 	"github.com/kohkimakimoto/cofu/cofu"
+	"github.com/labstack/gommon/log"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -37,3 +39,40 @@ execute "echo hogehoge"
 		t.Errorf("unexpected result %v", output)
 	}
 }
+
+func TestExecuteSensitiveOutput(t *testing.T) {
+	app := cofu.NewApp()
+	defer app.Close()
+	app.ResourceTypes = ResourceTypes
+
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := new(bytes.Buffer)
+	app.Logger.SetOutput(stdout)
+	app.Logger.SetLevel(log.DEBUG)
+
+	if err := app.LoadRecipe(`
+execute "visible" {
+    command = "echo visible-output",
+}
+execute "hidden" {
+    command = "echo hidden-output",
+    sensitive = true,
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Run(false); err != nil {
+		t.Fatal(err)
+	}
+
+	output := stdout.String()
+	if !strings.Contains(output, "visible-output") {
+		t.Errorf("the output must be logged %v", output)
+	}
+	if strings.Contains(output, "hidden-output") {
+		t.Errorf("the output of the sensitive resource must be hidden %v", output)
+	}
+}
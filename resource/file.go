@@ -23,7 +23,8 @@ var File = &cofu.ResourceType{
 			Name: "content",
 		},
 		&cofu.StringAttribute{
-			Name: "mode",
+			Name:    "mode",
+			Pattern: fileModePattern,
 		},
 		&cofu.StringAttribute{
 			Name: "owner",
@@ -69,7 +70,7 @@ func filePreAction(r *cofu.Resource) error {
 			temppath = t
 		} else if r.Attributes["source"] != nil {
 			// "source" is used "remote_file" resource
-			t, err := r.SendFileToTempfile(r.GetStringAttribute("source"))
+			t, err := r.SendFileToTempfile(r.Path(r.GetStringAttribute("source")))
 			if err != nil {
 				return err
 			}
@@ -21,6 +21,7 @@ var Group = &cofu.ResourceType{
 		},
 		&cofu.IntegerAttribute{
 			Name: "gid",
+			Min:  &minID,
 		},
 	},
 	PreAction:                groupPreAction,
@@ -42,15 +42,11 @@ func luaFunctionSetCurrentAttributes(r *cofu.Resource) error {
 func luaFunctionRunAction(r *cofu.Resource) error {
 	fn := r.GetLFunctionAttribute("func")
 
-	L := r.App.LState
-	err := L.CallByParam(lua.P{
-		Fn:      fn,
-		NRet:    0,
-		Protect: true,
-	}, nil)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return r.CallLState(func(L *lua.LState) error {
+		return L.CallByParam(lua.P{
+			Fn:      fn,
+			NRet:    0,
+			Protect: true,
+		}, nil)
+	})
 }
@@ -25,7 +25,8 @@ var RemoteDirectory = &cofu.ResourceType{
 			Required: true,
 		},
 		&cofu.StringAttribute{
-			Name: "mode",
+			Name:    "mode",
+			Pattern: fileModePattern,
 		},
 		&cofu.StringAttribute{
 			Name: "owner",
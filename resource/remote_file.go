@@ -25,7 +25,8 @@ var RemoteFile = &cofu.ResourceType{
 			Name: "source",
 		},
 		&cofu.StringAttribute{
-			Name: "mode",
+			Name:    "mode",
+			Pattern: fileModePattern,
 		},
 		&cofu.StringAttribute{
 			Name: "owner",
@@ -2,6 +2,7 @@ package resource
 
 import (
 	"github.com/kohkimakimoto/cofu/cofu"
+	"regexp"
 )
 
 var ResourceTypes = []*cofu.ResourceType{
@@ -19,3 +20,9 @@ var ResourceTypes = []*cofu.ResourceType{
 	Template,
 	User,
 }
+
+// fileModePattern is a pattern of 'mode' attribute like '644' or '0755'.
+var fileModePattern = regexp.MustCompile(`^[0-7]{3,4}$`)
+
+// minID is the minimum value of uid and gid.
+var minID = 0
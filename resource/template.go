@@ -36,7 +36,8 @@ var Template = &cofu.ResourceType{
 			Default: map[string]interface{}{},
 		},
 		&cofu.StringAttribute{
-			Name: "mode",
+			Name:    "mode",
+			Pattern: fileModePattern,
 		},
 		&cofu.StringAttribute{
 			Name: "owner",
@@ -48,6 +49,7 @@ var Template = &cofu.ResourceType{
 	PreAction:                templatePreAction,
 	SetCurrentAttributesFunc: templateSetCurrentAttributes,
 	ShowDifferences:          templateShowDifferences,
+	ValidateFunc:             templateValidate,
 	Actions: map[string]cofu.ResourceAction{
 		"create": templateCreateAction,
 		"delete": templateDeleteAction,
@@ -55,7 +57,7 @@ var Template = &cofu.ResourceType{
 }
 
 func templatePreAction(r *cofu.Resource) error {
-	logger := r.App.Logger
+	logger := r.Logger()
 	var templateContent string
 
 	if r.Attributes["content"] != nil {
@@ -63,32 +65,16 @@ func templatePreAction(r *cofu.Resource) error {
 	} else {
 		if r.Attributes["source"] == nil {
 			// try to load default source
-			p := r.GetStringAttribute("path")
-
-			p1 := filepath.Join(r.Basepath, "templates", p)
-			p2 := filepath.Join(r.Basepath, "files", p)
-
-			paths := []string{
-				p1 + ".tmpl",
-				p1,
-				p2 + ".tmpl",
-				p2,
-			}
-
-			for _, ps := range paths {
-				if _, err := os.Stat(ps); err == nil {
-					logger.Debugf("'%s' is used as template file", ps)
-					r.Attributes["source"] = ps
-					break
-				}
+			source, err := templateDefaultSource(r)
+			if err != nil {
+				return err
 			}
 
-			if r.Attributes["source"] == nil {
-				return fmt.Errorf("not exists: %v", paths)
-			}
+			logger.Debugf("'%s' is used as template file", source)
+			r.Attributes["source"] = source
 		}
 
-		b, err := ioutil.ReadFile(r.GetStringAttribute("source"))
+		b, err := ioutil.ReadFile(r.Path(r.GetStringAttribute("source")))
 		if err != nil {
 			return err
 		}
@@ -109,12 +95,17 @@ func templatePreAction(r *cofu.Resource) error {
 
 	// load global 'var' variable
 	gVar := map[string]interface{}{}
-	gVartb := r.App.LState.GetGlobal("var").(*lua.LTable)
-	gluamapper.NewMapper(gluamapper.Option{
-		NameFunc: func(s string) string {
-			return s
-		},
-	}).Map(gVartb, &gVar)
+	err = r.CallLState(func(L *lua.LState) error {
+		gVartb := L.GetGlobal("var").(*lua.LTable)
+		return gluamapper.NewMapper(gluamapper.Option{
+			NameFunc: func(s string) string {
+				return s
+			},
+		}).Map(gVartb, &gVar)
+	})
+	if err != nil {
+		return err
+	}
 	variables["var"] = gVar
 
 	var b bytes.Buffer
@@ -128,6 +119,54 @@ func templatePreAction(r *cofu.Resource) error {
 	return filePreAction(r)
 }
 
+// templateDefaultSource finds the template file from 'templates' and 'files' directories.
+func templateDefaultSource(r *cofu.Resource) (string, error) {
+	p := r.GetStringAttribute("path")
+
+	p1 := filepath.Join(r.Basepath, "templates", p)
+	p2 := filepath.Join(r.Basepath, "files", p)
+
+	paths := []string{
+		p1 + ".tmpl",
+		p1,
+		p2 + ".tmpl",
+		p2,
+	}
+
+	for _, ps := range paths {
+		if _, err := os.Stat(ps); err == nil {
+			return ps, nil
+		}
+	}
+
+	return "", fmt.Errorf("not exists: %v", paths)
+}
+
+func templateValidate(r *cofu.Resource) error {
+	create := false
+	for _, action := range r.GetStringSliceAttribute("action") {
+		if action == "create" {
+			create = true
+		}
+	}
+
+	if !create || r.Attributes["content"] != nil || r.HasLazyAttribute("content") || r.HasLazyAttribute("source") || r.HasLazyAttribute("path") {
+		return nil
+	}
+
+	if r.Attributes["source"] == nil {
+		_, err := templateDefaultSource(r)
+		return err
+	}
+
+	source := r.Path(r.GetStringAttribute("source"))
+	if _, err := os.Stat(source); err != nil {
+		return fmt.Errorf("template source '%s' is not found.", source)
+	}
+
+	return nil
+}
+
 func templateSetCurrentAttributes(r *cofu.Resource) error {
 	return fileSetCurrentAttributes(r)
 }
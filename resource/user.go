@@ -25,7 +25,8 @@ var User = &cofu.ResourceType{
 			Name: "home",
 		},
 		&cofu.StringAttribute{
-			Name: "password",
+			Name:      "password",
+			Sensitive: true,
 		},
 		&cofu.BoolAttribute{
 			Name:    "system_user",
@@ -33,6 +34,7 @@ var User = &cofu.ResourceType{
 		},
 		&cofu.IntegerAttribute{
 			Name: "uid",
+			Min:  &minID,
 		},
 		&cofu.StringAttribute{
 			Name: "shell",